@@ -0,0 +1,183 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type accessLogFormat int
+
+const (
+	accessLogCommon accessLogFormat = iota
+	accessLogCombined
+	accessLogJSON
+)
+
+func parseAccessLogFormat(name string) (accessLogFormat, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "common":
+		return accessLogCommon, nil
+	case "combined":
+		return accessLogCombined, nil
+	case "json":
+		return accessLogJSON, nil
+	}
+	return accessLogCommon, fmt.Errorf("unknown access log format '%s'", name)
+}
+
+var tlsVersionNames = map[uint16]string{
+	tls.VersionSSL30: "SSLv3",
+	tls.VersionTLS10: "TLSv1",
+	tls.VersionTLS11: "TLSv1.1",
+	tls.VersionTLS12: "TLSv1.2",
+	0x0304:           "TLSv1.3",
+}
+
+// accessLog is the destination and format of an HTTP access log.
+type accessLog struct {
+	*logDispatcher
+	format accessLogFormat
+}
+
+// accessLogHandler logs every request served by handler.
+type accessLogHandler struct {
+	handler http.Handler
+	*accessLog
+}
+
+func newAccessLogHandler(handler http.Handler, accessLog *accessLog) http.Handler {
+	return &accessLogHandler{handler, accessLog}
+}
+
+func (handler *accessLogHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	recorder := &accessLogResponseWriter{ResponseWriter: w}
+	handler.handler.ServeHTTP(recorder, r)
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	handler.log(logLevelInfo, handler.entry(r, recorder, start, time.Since(start)))
+}
+
+func (handler *accessLogHandler) entry(r *http.Request, recorder *accessLogResponseWriter, start time.Time, latency time.Duration) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+
+	user := ""
+	if r.URL.User != nil && r.URL.User.Username() != "" {
+		user = r.URL.User.Username()
+	} else if name, _, ok := r.BasicAuth(); ok && name != "" {
+		user = name
+	}
+
+	tlsVersion := ""
+	if r.TLS != nil {
+		if tlsVersion = tlsVersionNames[r.TLS.Version]; tlsVersion == "" {
+			tlsVersion = fmt.Sprintf("0x%04x", r.TLS.Version)
+		}
+	}
+
+	if handler.format == accessLogJSON {
+		data, _ := json.Marshal(&struct {
+			Time      string  `json:"time"`
+			Remote    string  `json:"remote"`
+			User      string  `json:"user,omitempty"`
+			Method    string  `json:"method"`
+			URI       string  `json:"uri"`
+			Protocol  string  `json:"protocol"`
+			Status    int     `json:"status"`
+			Bytes     int64   `json:"bytes"`
+			Latency   float64 `json:"latency"`
+			TLS       string  `json:"tls,omitempty"`
+			Referer   string  `json:"referer,omitempty"`
+			UserAgent string  `json:"user_agent,omitempty"`
+		}{
+			start.Format(time.RFC3339Nano),
+			remote,
+			user,
+			r.Method,
+			r.RequestURI,
+			r.Proto,
+			recorder.status,
+			recorder.bytes,
+			latency.Seconds(),
+			tlsVersion,
+			r.Referer(),
+			r.UserAgent(),
+		})
+		return string(data)
+	}
+
+	entry := fmt.Sprintf("%s - %s [%s] %q %d %d", remote, accessLogValue(user), start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method+" "+r.RequestURI+" "+r.Proto, recorder.status, recorder.bytes)
+	if handler.format == accessLogCombined {
+		entry += fmt.Sprintf(" %q %q", r.Referer(), r.UserAgent())
+	}
+	// Latency in seconds and the TLS version extend the standard formats.
+	return entry + fmt.Sprintf(" %.6f %s", latency.Seconds(), accessLogValue(tlsVersion))
+}
+
+// accessLogValue replaces empty values with a dash.
+func accessLogValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// accessLogResponseWriter records the status and size of a response.
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessLogResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessLogResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *accessLogResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+func (w *accessLogResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,46 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"regexp"
+	"testing"
+)
+
+func Test_AccessLog_LogsCommonFormatWithLatencyAndTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phoenix-access")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	logPath := path.Join(dir, "access.log")
+	container := &container{name: "test", logOptions: &logOptions{syslog: &syslogOptions{}}}
+	dispatcher, err := container.openAccessLog(logPath)
+	if err != nil {
+		t.Fatalf("Unexpected error opening access log: %v", err)
+	}
+
+	handler := newAccessLogHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}), &accessLog{dispatcher, accessLogCommon})
+
+	request := httptest.NewRequest("GET", "/pot?kind=tea", nil)
+	request.RemoteAddr = "192.0.2.1:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	dispatcher.Close()
+
+	data, _ := ioutil.ReadFile(logPath)
+	pattern := `^192\.0\.2\.1 - - \[[^]]+\] "GET /pot\?kind=tea HTTP/1\.1" 418 15 [0-9]+\.[0-9]{6} -\n$`
+	if !regexp.MustCompile(pattern).Match(data) {
+		t.Errorf("Access log entry %q does not match %s", data, pattern)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// newAdminHandler creates the handler for the administrative endpoints,
+// which are served on the addresses given by the [admin] listen option.
+func newAdminHandler(runtime *runtime) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/loglevel", runtime.serveLogLevel)
+	mux.HandleFunc("/health", runtime.serveHealth)
+	mux.HandleFunc("/livez", runtime.serveLive)
+	mux.HandleFunc("/readyz", runtime.serveReady)
+	return mux
+}
+
+// serveLogLevel reports the current log levels of all components, or
+// changes the level of one of them, e.g.
+//
+//	PUT /loglevel?component=http&level=debug&timeout=600
+//
+// The timeout gives the number of seconds after which the level will be
+// reverted, and defaults to [log] debugtimeout for the debug level.
+func (runtime *runtime) serveLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "HEAD":
+	case "PUT", "POST":
+		query := r.URL.Query()
+		level := query.Get("level")
+
+		revert := 0
+		if level == "debug" {
+			revert = runtime.GetIntDefault("log", "debugtimeout", 0)
+		}
+		if value := query.Get("timeout"); value != "" {
+			var err error
+			if revert, err = strconv.Atoi(value); err != nil {
+				http.Error(w, "invalid timeout", http.StatusBadRequest)
+				return
+			}
+		}
+
+		err := runtime.SetLogLevelFor(query.Get("component"), level, time.Duration(revert)*time.Second)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runtime.logwriter.thresholds.snapshot())
+}
@@ -0,0 +1,70 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serveLogLevel(runtime *runtime, method, target string) (int, map[string]string) {
+	recorder := httptest.NewRecorder()
+	runtime.serveLogLevel(recorder, httptest.NewRequest(method, target, nil))
+	levels := map[string]string{}
+	json.Unmarshal(recorder.Body.Bytes(), &levels)
+	return recorder.Code, levels
+}
+
+func Test_Runtime_ServeLogLevel_ChangesComponentLevels(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+
+	code, levels := serveLogLevel(runtime, "PUT", "/loglevel?component=http&level=warning")
+	if code != http.StatusOK || levels["http"] != "warning" {
+		t.Errorf("Expected the level of http to be changed, but got %d %v", code, levels)
+	}
+	if runtime.logwriter.thresholds.enabled("http", logLevelInfo) {
+		t.Errorf("Expected info messages of http to be dropped")
+	}
+
+	code, levels = serveLogLevel(runtime, "POST", "/loglevel?component=db&level=debug&timeout=600")
+	if code != http.StatusOK || levels["db"] != "debug" {
+		t.Errorf("Expected the level of db to be changed, but got %d %v", code, levels)
+	}
+	runtime.logwriter.thresholds.RLock()
+	_, reverts := runtime.logwriter.thresholds.reverts["db"]
+	runtime.logwriter.thresholds.RUnlock()
+	if !reverts {
+		t.Errorf("Expected the level of db to be reverted after the timeout")
+	}
+
+	code, levels = serveLogLevel(runtime, "GET", "/loglevel")
+	if code != http.StatusOK || levels["http"] != "warning" || levels["db"] != "debug" {
+		t.Errorf("Expected the current levels, but got %d %v", code, levels)
+	}
+}
+
+func Test_Runtime_ServeLogLevel_RejectsInvalidRequests(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+
+	for _, request := range []struct {
+		method, target string
+		code           int
+	}{
+		{"PUT", "/loglevel?component=http&level=verbose", http.StatusBadRequest},
+		{"PUT", "/loglevel?component=http&level=debug&timeout=soon", http.StatusBadRequest},
+		{"DELETE", "/loglevel", http.StatusMethodNotAllowed},
+	} {
+		if code, _ := serveLogLevel(runtime, request.method, request.target); code != request.code {
+			t.Errorf("Expected %s %s to yield status %d, but got %d", request.method, request.target, request.code, code)
+		}
+	}
+	if _, levels := serveLogLevel(runtime, "GET", "/loglevel"); levels["http"] != "" {
+		t.Errorf("Expected invalid requests not to change any level, but got %v", levels)
+	}
+}
@@ -22,17 +22,27 @@ func withTestServer(t *testing.T, server phoenix.Server, runFunc phoenix.RunFunc
 	signal.Notify(sig, os.Interrupt)
 	defer signal.Stop(sig)
 
+	ready := make(chan struct{})
 	go func() {
-		if err := server.Run(runFunc); err != nil {
+		err := server.Run(func(runtime phoenix.Runtime) error {
+			go func() {
+				<-runtime.Ready()
+				close(ready)
+			}()
+			return runFunc(runtime)
+		})
+		if err != nil {
 			t.Fatalf("Unexpected error starting server: %v", err)
 		} else {
 			t.Log("Server shutdown cleanly")
 		}
 	}()
 
-	// TODO(lcooper): If and when we can signal that we've bound all of our
-	// sockets, use that instead of waiting.
-	time.Sleep(1000 * time.Millisecond)
+	select {
+	case <-ready:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the server to become ready")
+	}
 
 	runTest()
 
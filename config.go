@@ -5,6 +5,8 @@
 package phoenix
 
 import (
+	"sync"
+
 	conf "github.com/dlintw/goconf"
 )
 
@@ -37,8 +39,11 @@ type ConfigUpdater interface {
 	Update(map[string]map[string]string) error
 }
 
+// config guards its options with a lock, as reloading replaces them while
+// services and admin requests may be reading them.
 type config struct {
-	*conf.ConfigFile
+	lock                sync.RWMutex
+	file                *conf.ConfigFile
 	path                string
 	defaultPath         string
 	overridePath        string
@@ -47,12 +52,60 @@ type config struct {
 
 func newConfig() *config {
 	return &config{
-		ConfigFile: conf.NewConfigFile(),
-		Defaults:   conf.NewConfigFile(),
-		Overrides:  conf.NewConfigFile(),
+		file:      conf.NewConfigFile(),
+		Defaults:  conf.NewConfigFile(),
+		Overrides: conf.NewConfigFile(),
 	}
 }
 
+func (config *config) HasSection(section string) bool {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.file.HasSection(section)
+}
+
+func (config *config) GetSections() []string {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.file.GetSections()
+}
+
+func (config *config) GetOptions(section string) ([]string, error) {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.file.GetOptions(section)
+}
+
+func (config *config) HasOption(section, option string) bool {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.file.HasOption(section, option)
+}
+
+func (config *config) GetBool(section, option string) (bool, error) {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.file.GetBool(section, option)
+}
+
+func (config *config) GetInt(section, option string) (int, error) {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.file.GetInt(section, option)
+}
+
+func (config *config) GetFloat64(section, option string) (float64, error) {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.file.GetFloat64(section, option)
+}
+
+func (config *config) GetString(section, option string) (string, error) {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.file.GetString(section, option)
+}
+
 func (config *config) GetBoolDefault(section, option string, dflt bool) bool {
 	if value, err := config.GetBool(section, option); err == nil {
 		return value
@@ -126,23 +179,26 @@ func (config *config) OverrideOption(section, name, value string) {
 }
 
 func (config *config) Update(updates map[string]map[string]string) error {
+	config.lock.Lock()
+	defer config.lock.Unlock()
 	for section, options := range updates {
 		for option, value := range options {
-			config.ConfigFile.AddOption(section, option, value)
+			config.file.AddOption(section, option, value)
 		}
 	}
 
 	return nil
 }
 
+// load reads the configuration files and replaces the current options
+// once all of them were read successfully.
 func (config *config) load() (err error) {
+	file := conf.NewConfigFile()
 	if config.HasPath() {
-		config.ConfigFile, err = conf.ReadConfigFile(config.Path())
+		file, err = conf.ReadConfigFile(config.Path())
 		if err != nil {
 			return
 		}
-	} else {
-		config.ConfigFile = conf.NewConfigFile()
 	}
 	if config.HasDefaultPath() {
 		// Load defaults if a path was given.
@@ -162,9 +218,9 @@ func (config *config) load() (err error) {
 	for _, section := range config.Defaults.GetSections() {
 		options, _ := config.Defaults.GetOptions(section)
 		for _, option := range options {
-			if !config.ConfigFile.HasOption(section, option) {
+			if !file.HasOption(section, option) {
 				value, _ := config.Defaults.GetRawString(section, option)
-				config.ConfigFile.AddOption(section, option, value)
+				file.AddOption(section, option, value)
 			}
 		}
 	}
@@ -173,9 +229,12 @@ func (config *config) load() (err error) {
 		options, _ := config.Overrides.GetOptions(section)
 		for _, option := range options {
 			value, _ := config.Overrides.GetRawString(section, option)
-			config.ConfigFile.AddOption(section, option, value)
+			file.AddOption(section, option, value)
 		}
 	}
 
+	config.lock.Lock()
+	config.file = file
+	config.lock.Unlock()
 	return
 }
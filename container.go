@@ -5,20 +5,32 @@
 package phoenix
 
 import (
-	"io"
+	"fmt"
 	"log"
-	"log/syslog"
+	"time"
 )
 
 // Logger provides a log-only interface to the application Logger.
 //
-// Presently only methods for logging at the default (debug) level
-// are provided, this may change in the future.
+// Messages are logged at the info level, which syslog outputs always
+// used for them, see LevelLogger for logging at other levels.
 type Logger interface {
 	Print(...interface{})
 	Printf(string, ...interface{})
 }
 
+// LevelLogger extends Logger with methods for logging at a given level.
+//
+// Each log output may be configured to discard messages below a minimum
+// level.
+type LevelLogger interface {
+	Logger
+	Debugf(string, ...interface{})
+	Infof(string, ...interface{})
+	Warningf(string, ...interface{})
+	Errorf(string, ...interface{})
+}
+
 // Metadata provides access to application information such as name and version.
 type Metadata interface {
 	// Name returns the the configured application name,
@@ -36,63 +48,95 @@ type Metadata interface {
 // Typically subinterfaces should be used when possible.
 type Container interface {
 	ConfigUpdater
-	Logger
+	LevelLogger
 	Metadata
+
+	// ComponentLogger returns a logger for the named component. Its
+	// messages are logged at or above the component's level, which
+	// defaults to the [log] level option and may be configured per
+	// component using the option level.<component>.
+	ComponentLogger(component string) LevelLogger
 }
 
 type container struct {
 	name, version string
-	logwriter     io.Writer
+	logwriter     *logDispatcher
 	*log.Logger
 	*config
+	logOptions *logOptions
+	// accessLogs are additional logs for preformatted lines.
+	accessLogs []*logDispatcher
+	// restoreGlobals undoes changes made to global loggers, last first.
+	restoreGlobals []func()
 }
 
-func newContainer(name, version string, logPath *string, config *config) (result *container, err error) {
+// newContainer creates a container logging to the configured outputs.
+// Unless globalLogger is false, the core logging package (and log/slog
+// if so configured) is redirected to the container for its lifetime.
+func newContainer(name, version string, logPath *string, config *config, globalLogger bool) (result *container, err error) {
 	if config != nil {
 		if err := config.load(); err != nil {
 			return nil, err
 		}
 	}
 
-	var logfile string
-	if logPath == nil || *logPath == "" {
+	options, err := newLogOptions(name, version, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var logfile, outputs string
+	if config != nil {
 		logfile, _ = config.GetString("log", "logfile")
-	} else {
-		logfile = *logPath
+		outputs, _ = config.GetString("log", "outputs")
 	}
 
-	var logwriter io.Writer
-	var logger *log.Logger
-	if logfile == "syslog" {
-		logwriter, err = syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, name)
+	var sinks []*outputSink
+	if logPath != nil && *logPath != "" {
+		// An explicitly given log path replaces any configured outputs.
+		sink, err := openLegacyLogSink(*logPath, options)
 		if err != nil {
 			return nil, err
 		}
-
-		// Syslog automatically adds a the tag as prefix
-		setSystemLogger("", logwriter)
-
-		logger = log.New(logwriter, "", log.LstdFlags&^(log.Ldate|log.Ltime))
+		sinks = append(sinks, sink)
+	} else if outputs != "" {
+		if sinks, err = openLogSinks(outputs, options); err != nil {
+			return nil, err
+		}
 	} else {
-		logwriter, err = openLogWriter(logfile)
+		sink, err := openLegacyLogSink(logfile, options)
 		if err != nil {
 			return nil, err
 		}
-
-		// Set the core logging package to log to our logwriter.
-		setSystemLogger(name, logwriter)
-
-		// And create our internal logger instance.
-		logger = makeLogger(name, logwriter)
+		sinks = append(sinks, sink)
 	}
 
-	return &container{
+	thresholds := newLogThresholds(options.levels)
+	limiter := newLogRateLimiter(options.rateLimit, options.rateInterval)
+	logwriter := newLogDispatcher(name, sinks, thresholds, limiter)
+
+	result = &container{
 		name,
 		version,
 		logwriter,
-		logger,
+		log.New(logwriter, "", 0),
 		config,
-	}, nil
+		options,
+		nil,
+		nil,
+	}
+
+	if globalLogger {
+		// Set the core logging package to log to our logwriter.
+		result.restoreGlobals = append(result.restoreGlobals, setSystemLogger(logwriter))
+
+		if config != nil && config.GetBoolDefault("log", "slogdefault", false) {
+			// Route log/slog, and with it the core logging package, to us.
+			result.restoreGlobals = append(result.restoreGlobals, installSlogDefault(result))
+		}
+	}
+
+	return result, nil
 }
 
 func (container *container) Name() string {
@@ -109,9 +153,96 @@ func (container *container) Version() string {
 	return container.version
 }
 
-func (container *container) Close() error {
-	if closer, ok := container.logwriter.(io.WriteCloser); ok {
-		return closer.Close()
+func (container *container) logTarget() (*logDispatcher, string) {
+	return container.logwriter, ""
+}
+
+func (container *container) Print(v ...interface{}) {
+	container.logwriter.log(logLevelInfo, fmt.Sprint(v...))
+}
+
+func (container *container) Printf(format string, v ...interface{}) {
+	container.logwriter.logf("", logLevelInfo, format, v)
+}
+
+func (container *container) Debugf(format string, v ...interface{}) {
+	container.logwriter.logf("", logLevelDebug, format, v)
+}
+
+func (container *container) Infof(format string, v ...interface{}) {
+	container.logwriter.logf("", logLevelInfo, format, v)
+}
+
+func (container *container) Warningf(format string, v ...interface{}) {
+	container.logwriter.logf("", logLevelWarning, format, v)
+}
+
+func (container *container) Errorf(format string, v ...interface{}) {
+	container.logwriter.logf("", logLevelError, format, v)
+}
+
+func (container *container) ComponentLogger(component string) LevelLogger {
+	return &componentLogger{container.logwriter, component}
+}
+
+func (container *container) SetLogLevel(component, level string) error {
+	return container.SetLogLevelFor(component, level, 0)
+}
+
+func (container *container) SetLogLevelFor(component, level string, revert time.Duration) error {
+	parsed, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+
+	container.logwriter.thresholds.set(component, parsed, revert)
+	if revert > 0 {
+		container.Infof("Log level of component '%s' set to %s for %s", component, parsed, revert)
+	} else {
+		container.Infof("Log level of component '%s' set to %s", component, parsed)
 	}
 	return nil
 }
+
+// openAccessLog opens a log for preformatted lines at destination, which
+// is "stderr", "syslog", "journald" or the path of a file. It is reopened
+// and closed along with the container's own log.
+func (container *container) openAccessLog(destination string) (*logDispatcher, error) {
+	if destination == "stderr" {
+		destination = ""
+	}
+
+	sink, err := openSingleLogSink(destination, container.logOptions, logFormatRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	thresholds := newLogThresholds(map[string]logLevel{"": logLevelDebug})
+	accessLog := newLogDispatcher(container.name, []*outputSink{sink}, thresholds, nil)
+	container.accessLogs = append(container.accessLogs, accessLog)
+	return accessLog, nil
+}
+
+// reopenLogs reopens all log files, e.g. after they have been rotated.
+func (container *container) reopenLogs() error {
+	faults := &multiError{}
+	faults.AddError(container.logwriter.reopen())
+	for _, accessLog := range container.accessLogs {
+		faults.AddError(accessLog.reopen())
+	}
+	return faults.AsError()
+}
+
+func (container *container) Close() error {
+	for i := len(container.restoreGlobals) - 1; i >= 0; i-- {
+		container.restoreGlobals[i]()
+	}
+	container.restoreGlobals = nil
+
+	faults := &multiError{}
+	for _, accessLog := range container.accessLogs {
+		faults.AddError(accessLog.Close())
+	}
+	faults.AddError(container.logwriter.Close())
+	return faults.AsError()
+}
@@ -6,8 +6,10 @@ package phoenix
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -18,6 +20,9 @@ func newTestContainer(name, version string) Container {
 		nil,
 		nil,
 		nil,
+		nil,
+		nil,
+		nil,
 	}
 }
 
@@ -53,7 +58,7 @@ func Test_Container_Name_DefaultstoUnreleasedIfUnset(t *testing.T) {
 
 func Test_Container_Syslog(t *testing.T) {
 	logFilename := "syslog"
-	container, err := newContainer("test", "", &logFilename, nil)
+	container, err := newContainer("test", "", &logFilename, nil, true)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "WARNING: Could not create container, test will be skipped: '%v'\n", err)
 		return
@@ -72,3 +77,32 @@ func Test_Container_Syslog(t *testing.T) {
 		t.Errorf("Logfile '%s' could not be opened but should not exist: '%v'", logFilename, err)
 	}
 }
+
+func Test_Container_WithoutGlobalLogger_LeavesTheLogPackageAlone(t *testing.T) {
+	logFile, err := ioutil.TempFile("", "phoenix-container")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	logFile.Close()
+	defer os.Remove(logFile.Name())
+
+	output := log.Writer()
+	logFilename := logFile.Name()
+	container, err := newContainer("test", "", &logFilename, nil, false)
+	if err != nil {
+		t.Fatalf("Unexpected error creating container: %v", err)
+	}
+
+	if log.Writer() != output {
+		t.Errorf("Expected the output of the log package to be left unchanged")
+	}
+	container.Infof("container message")
+	if err := container.Close(); err != nil {
+		t.Errorf("Unexpected error closing the container: %v", err)
+	}
+
+	data, _ := ioutil.ReadFile(logFilename)
+	if !strings.Contains(string(data), "container message") {
+		t.Errorf("Expected the container to log to its own file, but got %q", data)
+	}
+}
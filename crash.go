@@ -0,0 +1,137 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	goruntime "runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CrashHandler may add application specific context to a crash report.
+type CrashHandler func(io.Writer)
+
+// redactedOptions lists substrings of option names whose values are
+// withheld from crash reports.
+var redactedOptions = []string{"password", "passphrase", "secret", "token", "key", "credential"}
+
+// captureStack returns the stack of the calling goroutine, or of all
+// goroutines if all is set.
+func captureStack(all bool) []byte {
+	stackTrace := make([]byte, 1024)
+	for {
+		n := goruntime.Stack(stackTrace, all)
+		if n < len(stackTrace) {
+			return stackTrace[0:n]
+		}
+		stackTrace = make([]byte, len(stackTrace)*2)
+	}
+}
+
+// reportCrash logs err along with the current goroutine's stack, or all
+// goroutines' stacks if [runtime] panicallgoroutines is set, and writes a
+// crash report if [runtime] crashdir is set. It is meant to be called
+// from a deferred function which recovered from a panic, and returns the
+// captured stack.
+func (runtime *runtime) reportCrash(err error) []byte {
+	stackTrace := captureStack(runtime.GetBoolDefault("runtime", "panicallgoroutines", false))
+	runtime.logwriter.logUnlimited(logLevelError, fmt.Sprintf("%v\n%s", err, stackTrace))
+
+	if crashdir := runtime.GetStringDefault("runtime", "crashdir", ""); crashdir != "" {
+		if reportPath, reportErr := writeCrashReport(crashdir, runtime, err, stackTrace); reportErr != nil {
+			runtime.Errorf("Failed to write crash report: %v", reportErr)
+		} else {
+			runtime.Errorf("Crash report written to %s", reportPath)
+		}
+	}
+	return stackTrace
+}
+
+// writeCrashReport writes a report about the crash described by err and
+// stackTrace into a new file within dir, returning the file's path.
+func writeCrashReport(dir string, runtime *runtime, err error, stackTrace []byte) (string, error) {
+	now := time.Now()
+	name := fmt.Sprintf("%s-crash-%s-%d.txt", runtime.Name(), now.Format("20060102T150405.000000"), os.Getpid())
+	reportPath := path.Join(path.Clean(dir), name)
+
+	report := &bytes.Buffer{}
+	fmt.Fprintf(report, "Application: %s\n", runtime.Name())
+	fmt.Fprintf(report, "Version: %s\n", runtime.Version())
+	fmt.Fprintf(report, "Time: %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(report, "Uptime: %s\n", now.Sub(runtime.started))
+	fmt.Fprintf(report, "Go: %s %s/%s\n", goruntime.Version(), goruntime.GOOS, goruntime.GOARCH)
+	fmt.Fprintf(report, "Error: %v\n", err)
+
+	report.WriteString("\nConfiguration:\n")
+	writeRedactedConfig(report, runtime)
+
+	var memStats goruntime.MemStats
+	goruntime.ReadMemStats(&memStats)
+	report.WriteString("\nMemory:\n")
+	fmt.Fprintf(report, "  Alloc: %d\n  TotalAlloc: %d\n  Sys: %d\n  HeapObjects: %d\n  NumGC: %d\n  Goroutines: %d\n",
+		memStats.Alloc, memStats.TotalAlloc, memStats.Sys, memStats.HeapObjects, memStats.NumGC, goruntime.NumGoroutine())
+
+	for _, handler := range runtime.crashHandlers {
+		report.WriteString("\nContext:\n")
+		runCrashHandler(report, handler)
+	}
+
+	report.WriteString("\nStack:\n")
+	report.Write(stackTrace)
+
+	file, err := os.OpenFile(reportPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	_, err = file.Write(report.Bytes())
+	return reportPath, err
+}
+
+func runCrashHandler(w io.Writer, handler CrashHandler) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			fmt.Fprintf(w, "crash handler panicked: %v\n", recovered)
+		}
+	}()
+	handler(w)
+}
+
+func writeRedactedConfig(w io.Writer, config Config) {
+	sections := config.GetSections()
+	sort.Strings(sections)
+	for _, section := range sections {
+		options, _ := config.GetOptions(section)
+		if len(options) == 0 {
+			continue
+		}
+		sort.Strings(options)
+
+		fmt.Fprintf(w, "  [%s]\n", section)
+		for _, option := range options {
+			value := config.GetStringDefault(section, option, "")
+			if isRedactedOption(option) {
+				value = "<redacted>"
+			}
+			fmt.Fprintf(w, "  %s = %s\n", option, value)
+		}
+	}
+}
+
+func isRedactedOption(option string) bool {
+	option = strings.ToLower(option)
+	for _, redacted := range redactedOptions {
+		if strings.Contains(option, redacted) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func Test_Server_Run_WritesCrashReportOnPanic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phoenix-crash")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	logPath := path.Join(dir, "app.log")
+	err = NewServer("crashing", "1.0").
+		Log(&logPath).
+		GlobalLogger(false).
+		OverrideOption("runtime", "crashdir", dir).
+		OverrideOption("runtime", "panicallgoroutines", "true").
+		OverrideOption("db", "password", "hunter2").
+		Run(func(runtime Runtime) error {
+			runtime.OnCrash(func(w io.Writer) {
+				fmt.Fprintln(w, "open transactions: 3")
+			})
+			panic("boom")
+		})
+	if err == nil || err.Error() != "boom" {
+		t.Fatalf("Expected the panic to be returned as an error, but got '%v'", err)
+	}
+
+	reports, _ := findFiles(dir, "crashing-crash-")
+	if len(reports) != 1 {
+		t.Fatalf("Expected exactly one crash report, but found %v", reports)
+	}
+	data, _ := ioutil.ReadFile(reports[0])
+	report := string(data)
+	for _, expected := range []string{"Version: 1.0", "Error: boom", "password = <redacted>", "open transactions: 3", "goroutine "} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("Expected crash report to contain '%s'", expected)
+		}
+	}
+	if strings.Contains(report, "hunter2") {
+		t.Errorf("Expected the password to be redacted from the crash report")
+	}
+}
+
+func findFiles(dir, prefix string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	var matches []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), prefix) {
+			matches = append(matches, path.Join(dir, entry.Name()))
+		}
+	}
+	return matches, err
+}
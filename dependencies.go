@@ -0,0 +1,165 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// NamedService may be implemented by services to provide the name by which
+// other services refer to them. Services without a name are named after
+// their type.
+type NamedService interface {
+	ServiceName() string
+}
+
+// DependentService may be implemented by services which must not be
+// started before the named services are ready. Such services are stopped
+// before the services they depend upon.
+type DependentService interface {
+	ServiceDependencies() []string
+}
+
+// resolveDependencies names all services, links them to the services they
+// depend upon, and orders them such that every service comes after its
+// dependencies. Unknown dependencies and cycles are reported as errors.
+func (manager *serviceManager) resolveDependencies() error {
+	manager.Lock()
+	defer manager.Unlock()
+
+	byName := make(map[string]*managedService, len(manager.services))
+	for _, service := range manager.services {
+		manager.nameService(service, byName)
+	}
+
+	for _, service := range manager.services {
+		service.deps, service.dependents = nil, nil
+	}
+	for _, service := range manager.services {
+		if err := manager.linkService(service, byName); err != nil {
+			return err
+		}
+	}
+
+	// Order services topologically, keeping the order of registration
+	// where possible.
+	ordered := make([]*managedService, 0, len(manager.services))
+	visiting := make(map[*managedService]bool)
+	visited := make(map[*managedService]bool)
+	var visit func(service *managedService, path []string) error
+	visit = func(service *managedService, path []string) error {
+		if visited[service] {
+			return nil
+		}
+		path = append(path, service.name)
+		if visiting[service] {
+			return fmt.Errorf("service dependency cycle: %s", strings.Join(path, " -> "))
+		}
+		visiting[service] = true
+		for _, dep := range service.deps {
+			if err := visit(dep, path); err != nil {
+				return err
+			}
+		}
+		visiting[service] = false
+		visited[service] = true
+		ordered = append(ordered, service)
+		return nil
+	}
+	for _, service := range manager.services {
+		if err := visit(service, nil); err != nil {
+			return err
+		}
+	}
+
+	manager.services = ordered
+	return nil
+}
+
+// nameService names service after its type or ServiceName, appending a
+// number if the name is taken already, and adds it to byName.
+func (manager *serviceManager) nameService(service *managedService, byName map[string]*managedService) {
+	name := service.baseName()
+	if _, exists := byName[name]; exists {
+		for i := 2; ; i++ {
+			if _, exists := byName[fmt.Sprintf("%s#%d", name, i)]; !exists {
+				name = fmt.Sprintf("%s#%d", name, i)
+				break
+			}
+		}
+	}
+	service.name = name
+	byName[name] = service
+}
+
+// linkService links service to the services it depends upon. It must be
+// called with the lock held.
+func (manager *serviceManager) linkService(service *managedService, byName map[string]*managedService) error {
+	var names []string
+	if dependent, ok := service.impl.(DependentService); ok {
+		names = dependent.ServiceDependencies()
+	}
+	for _, impl := range service.after {
+		dep := manager.find(impl)
+		if dep == nil {
+			return fmt.Errorf("service %s depends on a service which was not registered", service.name)
+		}
+		names = append(names, dep.name)
+	}
+
+	for _, name := range names {
+		dep, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("service %s depends on unknown service %s", service.name, name)
+		}
+		if dep.removing {
+			return fmt.Errorf("service %s depends on service %s, which is being removed", service.name, name)
+		}
+		service.deps = append(service.deps, dep)
+		dep.dependents = append(dep.dependents, service)
+	}
+	return nil
+}
+
+// unlinkService removes service from the dependents of the services it
+// depends upon. It must be called with the lock held.
+func unlinkService(service *managedService) {
+	for _, dep := range service.deps {
+		for i, dependent := range dep.dependents {
+			if dependent == service {
+				dep.dependents = append(dep.dependents[:i:i], dep.dependents[i+1:]...)
+				break
+			}
+		}
+	}
+	service.deps = nil
+}
+
+// servicesByName returns the registered services keyed by name. It must be
+// called with the lock held.
+func (manager *serviceManager) servicesByName() map[string]*managedService {
+	byName := make(map[string]*managedService, len(manager.services))
+	for _, service := range manager.services {
+		byName[service.name] = service
+	}
+	return byName
+}
+
+// find returns the managed service registered for impl, if any. Services
+// whose type is not comparable, like funcs or structs holding slices, are
+// never found. It must be called with the lock held.
+func (manager *serviceManager) find(impl interface{}) *managedService {
+	if impl == nil || !reflect.TypeOf(impl).Comparable() {
+		return nil
+	}
+	for _, service := range manager.services {
+		if service.impl == impl {
+			return service
+		}
+	}
+	return nil
+}
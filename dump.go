@@ -0,0 +1,44 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+// dumpState records the state of all services and the stacks of all
+// goroutines, either in the log or, if [runtime] dumpdir is set, in a
+// new timestamped file within that directory.
+func (runtime *runtime) dumpState() {
+	now := time.Now()
+
+	dump := &bytes.Buffer{}
+	fmt.Fprintf(dump, "State of %s %s at %s, up %s\n", runtime.Name(), runtime.Version(), now.Format(time.RFC3339), now.Sub(runtime.started))
+	dump.WriteString("\nServices:\n")
+	for _, state := range runtime.serviceStates() {
+		fmt.Fprintf(dump, "  %s\n", state)
+	}
+	dump.WriteString("\nGoroutines:\n")
+	dump.Write(captureStack(true))
+
+	dumpdir := runtime.GetStringDefault("runtime", "dumpdir", "")
+	if dumpdir == "" {
+		runtime.logwriter.logUnlimited(logLevelWarning, dump.String())
+		return
+	}
+
+	name := fmt.Sprintf("%s-dump-%s-%d.txt", runtime.Name(), now.Format("20060102T150405.000"), os.Getpid())
+	dumpPath := path.Join(path.Clean(dumpdir), name)
+	if err := ioutil.WriteFile(dumpPath, dump.Bytes(), 0600); err != nil {
+		runtime.Errorf("Failed to write goroutine dump: %v", err)
+		return
+	}
+	runtime.Warningf("Goroutine dump written to %s", dumpPath)
+}
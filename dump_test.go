@@ -0,0 +1,45 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func Test_Runtime_DumpState_WritesServicesAndGoroutines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phoenix-dump")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := newConfig()
+	config.OverrideOption("runtime", "dumpdir", dir)
+	logPath := path.Join(dir, "app.log")
+	container, err := newContainer("dumping", "", &logPath, config, false)
+	if err != nil {
+		t.Fatalf("Unexpected error creating container: %v", err)
+	}
+	defer container.Close()
+
+	runtime := newRuntime(container, nil)
+	runtime.Service(newHTTPService(nil, nil, "127.0.0.1:0", nil, 1, 1, 1, nil, nil))
+	runtime.dumpState()
+
+	dumps, _ := findFiles(dir, "dumping-dump-")
+	if len(dumps) != 1 {
+		t.Fatalf("Expected exactly one dump, but found %v", dumps)
+	}
+	data, _ := ioutil.ReadFile(dumps[0])
+	for _, expected := range []string{"HTTP server on 127.0.0.1:0: stopped", "goroutine "} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("Expected dump to contain '%s'", expected)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HealthChecker may be implemented by services which are able to tell
+// whether they are working properly, beyond having started successfully.
+type HealthChecker interface {
+	// Health returns an error describing why the service is unhealthy,
+	// or nil. The context carries the deadline given by [health] timeout.
+	Health(ctx context.Context) error
+}
+
+// serviceHealth is the health of a single service.
+type serviceHealth struct {
+	Name    string `json:"name"`
+	State   string `json:"state"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// healthReport aggregates the health of all services.
+type healthReport struct {
+	Healthy  bool            `json:"healthy"`
+	Checked  *time.Time      `json:"checked,omitempty"`
+	Services []serviceHealth `json:"services"`
+}
+
+// healthOf reports whether service is healthy, which is the case unless
+// it failed or its last health check did. It must be called with the lock
+// held.
+func healthOf(service *managedService) error {
+	if service.state == ServiceFailed {
+		return errors.New("service failed")
+	}
+	if service.state == ServiceRunning {
+		return service.healthErr
+	}
+	return nil
+}
+
+// checkHealth runs the health checks of all running services concurrently,
+// each limited to [health] timeout seconds, and returns the results.
+func (manager *serviceManager) checkHealth(ctx context.Context) *healthReport {
+	timeout := time.Duration(manager.GetIntDefault("health", "timeout", 5)) * time.Second
+
+	manager.Lock()
+	services := make([]*managedService, 0, len(manager.services))
+	for _, service := range manager.services {
+		if _, ok := service.impl.(HealthChecker); ok && service.state == ServiceRunning {
+			services = append(services, service)
+		}
+	}
+	manager.Unlock()
+
+	checking := sync.WaitGroup{}
+	for _, service := range services {
+		checking.Add(1)
+		go func(service *managedService) {
+			defer checking.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			result := make(chan error, 1)
+			go func() {
+				result <- manager.protect(service, func() error {
+					return service.impl.(HealthChecker).Health(checkCtx)
+				})
+			}()
+
+			var err error
+			select {
+			case err = <-result:
+			case <-checkCtx.Done():
+				err = errors.New("health check timed out")
+			}
+
+			manager.Lock()
+			if err != nil && service.healthErr == nil {
+				manager.Warningf("Service %s is unhealthy: %v", service, err)
+			} else if err == nil && service.healthErr != nil {
+				manager.Infof("Service %s is healthy again", service)
+			}
+			service.healthErr = err
+			manager.Unlock()
+		}(service)
+	}
+	checking.Wait()
+
+	manager.Lock()
+	manager.healthChecked = time.Now()
+	manager.Unlock()
+	return manager.healthReport()
+}
+
+// healthReport returns the results of the last health checks.
+func (manager *serviceManager) healthReport() *healthReport {
+	manager.Lock()
+	defer manager.Unlock()
+
+	report := &healthReport{Healthy: true, Services: make([]serviceHealth, 0, len(manager.services))}
+	if !manager.healthChecked.IsZero() {
+		checked := manager.healthChecked
+		report.Checked = &checked
+	}
+	for _, service := range manager.services {
+		health := serviceHealth{Name: service.String(), State: service.state.String(), Healthy: true}
+		if err := healthOf(service); err != nil {
+			health.Healthy = false
+			health.Error = err.Error()
+			report.Healthy = false
+		}
+		report.Services = append(report.Services, health)
+	}
+	return report
+}
+
+// pollHealth checks the health of all services every [health] interval
+// seconds until the manager is stopped. Polling is disabled if the
+// interval is zero, in which case health is checked on demand.
+func (manager *serviceManager) pollHealth() {
+	interval := time.Duration(manager.GetIntDefault("health", "interval", 10)) * time.Second
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			manager.checkHealth(manager.ctx)
+		case <-manager.ctx.Done():
+			return
+		}
+	}
+}
+
+// serveHealth reports the health of all services as JSON, responding with
+// 503 Service Unavailable if any of them is unhealthy. Unless health is
+// polled, services are checked on every request.
+func (runtime *runtime) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var report *healthReport
+	if runtime.GetIntDefault("health", "interval", 10) <= 0 {
+		report = runtime.checkHealth(r.Context())
+	} else {
+		report = runtime.healthReport()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if !report.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// serveLive responds with 200 OK unless a service has failed, so that the
+// process may be restarted by a supervisor probing it.
+func (runtime *runtime) serveLive(w http.ResponseWriter, r *http.Request) {
+	runtime.Lock()
+	failed := false
+	for _, service := range runtime.services {
+		failed = failed || service.state == ServiceFailed
+	}
+	runtime.Unlock()
+
+	if failed {
+		http.Error(w, "service failed", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// serveReady responds with 200 OK once all services are ready and healthy,
+// until the runtime begins to shut down, so that load balancers may stop
+// routing requests to it first.
+func (runtime *runtime) serveReady(w http.ResponseWriter, r *http.Request) {
+	var reason string
+	select {
+	case <-runtime.Ready():
+		if atomic.LoadInt32(&runtime.draining) != 0 {
+			reason = "shutting down"
+		} else if !runtime.healthy() {
+			reason = "unhealthy"
+		}
+	default:
+		reason = "starting"
+	}
+
+	if reason != "" {
+		http.Error(w, reason, http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// drain marks the runtime as no longer ready and waits [runtime] predrain
+// seconds for load balancers to notice, before stopping all services.
+func (runtime *runtime) drain() {
+	atomic.StoreInt32(&runtime.draining, 1)
+	if predrain := time.Duration(runtime.GetIntDefault("runtime", "predrain", 0)) * time.Second; predrain > 0 {
+		runtime.Infof("Waiting %s before stopping all services", predrain)
+		if err := runtime.notifier.notify("STATUS=Draining"); err != nil {
+			runtime.Warningf("Failed to notify service manager: %v", err)
+		}
+		select {
+		case <-time.After(predrain):
+		case <-runtime.stopped:
+		}
+	}
+	runtime.Stop()
+}
@@ -0,0 +1,152 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type checkedService struct {
+	contextService
+	healthErr error
+}
+
+func (service *checkedService) ServiceName() string {
+	return "checked"
+}
+
+func (service *checkedService) Health(ctx context.Context) error {
+	return service.healthErr
+}
+
+func Test_Runtime_ServeHealth_ReportsUnhealthyServices(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+	runtime.Update(map[string]map[string]string{"health": {"interval": "0"}})
+
+	service := &checkedService{contextService: contextService{started: make(chan struct{})}}
+	runtime.ContextService(service)
+	done := make(chan error, 1)
+	go func() {
+		done <- runtime.Start()
+	}()
+	<-runtime.Ready()
+	defer func() {
+		runtime.Stop()
+		<-done
+	}()
+
+	recorder := httptest.NewRecorder()
+	runtime.serveHealth(recorder, httptest.NewRequest("GET", "/health", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected a healthy service to yield status 200, but got %d", recorder.Code)
+	}
+
+	service.healthErr = errors.New("database unreachable")
+	recorder = httptest.NewRecorder()
+	runtime.serveHealth(recorder, httptest.NewRequest("GET", "/health", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected an unhealthy service to yield status 503, but got %d", recorder.Code)
+	}
+
+	report := &healthReport{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), report); err != nil {
+		t.Fatalf("Failed to decode health report: %v", err)
+	}
+	if report.Healthy || len(report.Services) != 1 {
+		t.Fatalf("Expected a single unhealthy service, but got %+v", report)
+	}
+	if health := report.Services[0]; health.Name != "checked" || health.State != "running" || health.Error != "database unreachable" {
+		t.Errorf("Expected the failing health check to be reported, but got %+v", health)
+	}
+	if runtime.healthy() {
+		t.Errorf("Expected the runtime to be considered unhealthy")
+	}
+}
+
+type slowHealthService struct {
+	contextService
+}
+
+func (service *slowHealthService) Health(ctx context.Context) error {
+	<-ctx.Done()
+	time.Sleep(10 * time.Millisecond)
+	return nil
+}
+
+func Test_ServiceManager_CheckHealth_TimesOut(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+	runtime.Update(map[string]map[string]string{"health": {"interval": "0", "timeout": "1"}})
+
+	runtime.ContextService(&slowHealthService{contextService{started: make(chan struct{})}})
+	done := make(chan error, 1)
+	go func() {
+		done <- runtime.Start()
+	}()
+	<-runtime.Ready()
+	defer func() {
+		runtime.Stop()
+		<-done
+	}()
+
+	report := runtime.checkHealth(context.Background())
+	if report.Healthy || report.Services[0].Error != "health check timed out" {
+		t.Errorf("Expected the health check to time out, but got %+v", report)
+	}
+}
+
+func serveProbe(handler http.HandlerFunc, path string) int {
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", path, nil))
+	return recorder.Code
+}
+
+func Test_Runtime_Drain_FailsReadinessBeforeStopping(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+	runtime.Update(map[string]map[string]string{"runtime": {"predrain": "1"}})
+
+	service := &contextService{started: make(chan struct{})}
+	runtime.ContextService(service)
+	if code := serveProbe(runtime.serveReady, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected readiness to fail before starting, but got %d", code)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runtime.Start()
+	}()
+	<-runtime.Ready()
+	if code := serveProbe(runtime.serveReady, "/readyz"); code != http.StatusOK {
+		t.Errorf("Expected readiness to succeed once started, but got %d", code)
+	}
+
+	go runtime.drain()
+	time.Sleep(100 * time.Millisecond)
+	if code := serveProbe(runtime.serveReady, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected readiness to fail while draining, but got %d", code)
+	}
+	if code := serveProbe(runtime.serveLive, "/livez"); code != http.StatusOK {
+		t.Errorf("Expected liveness to succeed while draining, but got %d", code)
+	}
+	select {
+	case <-done:
+		t.Fatalf("Expected services to keep running during the predrain delay")
+	default:
+	}
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Expected services to stop after the predrain delay")
+	}
+}
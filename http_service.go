@@ -5,55 +5,154 @@
 package phoenix
 
 import (
+	"context"
 	"crypto/tls"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
-
-	"github.com/strukturag/httputils"
 )
 
 type httpService struct {
-	*httputils.Server
+	server *http.Server
+	// entry is the listen entry the service was created for, and addr
+	// the address it listens on.
+	entry    string
+	addr     string
+	listener net.Listener
+	ready    func()
+	logger   LevelLogger
+	// shutdownTimeout is the time given to active connections to finish
+	// before they are closed forcibly.
+	shutdownTimeout time.Duration
+	// connections counts the open connections.
+	connections int64
 }
 
-func newHTTPService(logger *log.Logger, handler http.Handler, addr string, readtimeout, writetimeout int, tlsConfig *tls.Config) Service {
-	server := &httputils.Server{
-		Server: http.Server{
-			Addr:           addr,
-			Handler:        handler,
-			ReadTimeout:    time.Duration(readtimeout) * time.Second,
-			WriteTimeout:   time.Duration(writetimeout) * time.Second,
-			MaxHeaderBytes: 1 << 20,
-			TLSConfig:      tlsConfig,
-		},
-		Logger: logger,
+// newHTTPService creates a service serving handler on addr, or on listener
+// if it isn't nil, e.g. when passed in by systemd or an upgrading process.
+func newHTTPService(logger *log.Logger, handler http.Handler, addr string, listener net.Listener, readtimeout, writetimeout, shutdowntimeout int, tlsConfig *tls.Config, accessLog *accessLog) Service {
+	if accessLog != nil {
+		handler = newAccessLogHandler(handler, accessLog)
+	}
+
+	server := &http.Server{
+		Addr:           addr,
+		Handler:        handler,
+		ReadTimeout:    time.Duration(readtimeout) * time.Second,
+		WriteTimeout:   time.Duration(writetimeout) * time.Second,
+		MaxHeaderBytes: 1 << 20,
+		TLSConfig:      tlsConfig,
+		ErrorLog:       logger,
+	}
+	service := &httpService{
+		server:          server,
+		entry:           addr,
+		addr:            addr,
+		listener:        listener,
+		shutdownTimeout: time.Duration(shutdowntimeout) * time.Second,
 	}
-	return &httpService{server}
+	server.ConnState = service.trackConnection
+	if listener != nil {
+		service.addr = listener.Addr().String()
+	}
+	return service
+}
+
+func (service *httpService) SetReadyFunc(ready func()) {
+	service.ready = ready
 }
 
-func (service *httpService) OnStart(container Container) (err error) {
-	container.Printf("Starting %s server on %s", service.protocol(), service.addr())
+func (service *httpService) OnStart(container Container) error {
+	service.logger = container.ComponentLogger("http")
+	service.logger.Infof("Starting %s server on %s", service.protocol(), service.addr)
+
+	if service.listener == nil {
+		listener, err := net.Listen("tcp", service.addr)
+		if err != nil {
+			return err
+		}
+		service.listener = listener
+	}
 
-	if service.TLSConfig == nil {
-		err = service.Listen()
-	} else {
-		err = service.ListenTLSWithConfig(service.TLSConfig)
+	if service.ready != nil {
+		service.ready()
 	}
-	return
+	return nil
 }
 
-func (service *httpService) OnStop(container Container) {
-	container.Printf("Stopped %s server on %s", service.protocol(), service.addr())
+func (service *httpService) Start() error {
+	listener := service.listener
+	if service.server.TLSConfig != nil {
+		listener = tls.NewListener(listener, service.server.TLSConfig)
+	}
+
+	err := service.server.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	// Serve closed the listener, listen again when restarted.
+	service.listener = nil
+	return err
 }
 
-func (service *httpService) addr() string {
-	return service.Server.Server.Addr
+// Stop stops accepting connections and waits for active requests to
+// finish, closing all connections which remain open after the shutdown
+// timeout.
+func (service *httpService) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), service.shutdownTimeout)
+	defer cancel()
+
+	err := service.server.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		return err
+	}
+
+	open := atomic.LoadInt64(&service.connections)
+	if service.logger != nil {
+		service.logger.Warningf("Closing %d connections of %s server on %s still open after %s",
+			open, service.protocol(), service.addr, service.shutdownTimeout)
+	}
+	return service.server.Close()
+}
+
+// StopTimeout allows for the shutdown timeout and closing the remaining
+// connections afterwards.
+func (service *httpService) StopTimeout() time.Duration {
+	return service.shutdownTimeout + time.Second
+}
+
+func (service *httpService) trackConnection(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		atomic.AddInt64(&service.connections, 1)
+	case http.StateHijacked, http.StateClosed:
+		atomic.AddInt64(&service.connections, -1)
+	}
+}
+
+func (service *httpService) inheritableListener() (string, net.Listener) {
+	return service.entry, service.listener
+}
+
+func (service *httpService) OnStop(container Container) {
+	service.logger.Infof("Stopped %s server on %s", service.protocol(), service.addr)
 }
 
 func (service *httpService) protocol() string {
-	if service.Server.Server.TLSConfig != nil {
+	if service.server.TLSConfig != nil {
 		return "HTTPS"
 	}
 	return "HTTP"
 }
+
+func (service *httpService) ServiceName() string {
+	return strings.ToLower(service.protocol()) + ":" + service.addr
+}
+
+func (service *httpService) String() string {
+	return fmt.Sprintf("%s server on %s", service.protocol(), service.addr)
+}
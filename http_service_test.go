@@ -0,0 +1,129 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type blockingHandler struct {
+	entered, release chan struct{}
+}
+
+func (handler *blockingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	close(handler.entered)
+	<-handler.release
+	w.Write([]byte("done"))
+}
+
+func startDrainTestRuntime(t *testing.T, handler http.Handler) (*runtime, string, chan error, func()) {
+	runtime, cleanup := newTestRuntime(t)
+	runtime.Update(map[string]map[string]string{"http": {"listen": "127.0.0.1:0", "shutdowntimeout": "1"}})
+	runtime.DefaultHTTPHandler(handler)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runtime.Start()
+	}()
+	select {
+	case <-runtime.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the runtime to become ready")
+	}
+	url := "http://" + runtime.services[0].impl.(*httpService).listener.Addr().String() + "/"
+	return runtime, url, done, cleanup
+}
+
+func Test_HTTPService_Stop_DrainsActiveRequests(t *testing.T) {
+	handler := &blockingHandler{make(chan struct{}), make(chan struct{})}
+	runtime, url, done, cleanup := startDrainTestRuntime(t, handler)
+	defer cleanup()
+
+	result := make(chan string, 1)
+	go func() {
+		response, err := http.Get(url)
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		defer response.Body.Close()
+		body, _ := ioutil.ReadAll(response.Body)
+		result <- string(body)
+	}()
+	<-handler.entered
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(handler.release)
+	}()
+	if err := runtime.Stop(); err != nil {
+		t.Errorf("Unexpected error stopping: %v", err)
+	}
+	<-done
+
+	if body := <-result; body != "done" {
+		t.Errorf("Expected the active request to complete, but got '%s'", body)
+	}
+}
+
+func Test_HTTPService_Stop_ClosesConnectionsAfterShutdownTimeout(t *testing.T) {
+	handler := &blockingHandler{make(chan struct{}), make(chan struct{})}
+	defer close(handler.release)
+	runtime, url, done, cleanup := startDrainTestRuntime(t, handler)
+	defer cleanup()
+
+	failed := make(chan error, 1)
+	go func() {
+		response, err := http.Get(url)
+		if err == nil {
+			response.Body.Close()
+		}
+		failed <- err
+	}()
+	<-handler.entered
+
+	service := runtime.services[0].impl.(*httpService)
+	start := time.Now()
+	if err := runtime.Stop(); err != nil {
+		t.Errorf("Unexpected error stopping: %v", err)
+	}
+	<-done
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Expected Stop to wait for the shutdown timeout, but it returned after %s", elapsed)
+	}
+	if err := <-failed; err == nil {
+		t.Errorf("Expected the request to fail when its connection was closed")
+	}
+	if service.StopTimeout() <= service.shutdownTimeout {
+		t.Errorf("Expected the stop timeout to allow for the shutdown timeout")
+	}
+}
+
+func Test_Runtime_DefaultHTTPHandler_AddsServersWhileRunning(t *testing.T) {
+	runtime, _, done, cleanup := startDrainTestRuntime(t, http.NotFoundHandler())
+	defer cleanup()
+
+	runtime.DefaultHTTPHandler(http.NotFoundHandler())
+	statuses := runtime.Services()
+	if len(statuses) != 2 {
+		t.Fatalf("Expected the server to be added, but got %v", statuses)
+	}
+
+	callbacks := len(runtime.callbacks)
+	runtime.Update(map[string]map[string]string{"https": {"listen": "127.0.0.1:0"}})
+	runtime.DefaultHTTPSHandler(http.NotFoundHandler())
+	if len(runtime.Services()) != 2 || len(runtime.callbacks) != callbacks {
+		t.Errorf("Expected the misconfigured HTTPS server to be logged and skipped")
+	}
+
+	runtime.Stop()
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error from Start: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const journalDefaultSocket = "/run/systemd/journal/socket"
+
+// journalSink writes records to the systemd journal using its native
+// protocol, see https://systemd.io/JOURNAL_NATIVE_PROTOCOL/.
+//
+// Datagrams which are too large for the socket would have
+// to be passed as a sealed memfd instead, this is not supported and such
+// records are reported as failed writes.
+type journalSink struct {
+	socket     string
+	identifier string
+	version    string
+	conn       *net.UnixConn
+}
+
+func newJournalSink(options *logOptions) *journalSink {
+	return &journalSink{
+		socket:     options.journalSocket,
+		identifier: options.syslog.tag,
+		version:    options.version,
+	}
+}
+
+func (sink *journalSink) writeRecord(record *logRecord, format logFormat) error {
+	if sink.conn == nil {
+		conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: sink.socket, Net: "unixgram"})
+		if err != nil {
+			return err
+		}
+		sink.conn = conn
+	}
+
+	buf := &bytes.Buffer{}
+	writeJournalField(buf, "MESSAGE", string(record.formatMessage(format)))
+	writeJournalField(buf, "PRIORITY", strconv.Itoa(int(syslogSeverity(record.level))))
+	writeJournalField(buf, "SYSLOG_IDENTIFIER", sink.identifier)
+	if sink.version != "" {
+		writeJournalField(buf, "VERSION", sink.version)
+	}
+	for _, field := range record.fields {
+		if name := journalFieldName(field.key); name != "" {
+			writeJournalField(buf, name, field.value)
+		}
+	}
+
+	if _, err := sink.conn.Write(buf.Bytes()); err != nil {
+		sink.conn.Close()
+		sink.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (sink *journalSink) Close() error {
+	if sink.conn != nil {
+		return sink.conn.Close()
+	}
+	return nil
+}
+
+func writeJournalField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	if strings.ContainsRune(value, '\n') {
+		// Values containing newlines are length prefixed instead.
+		buf.WriteByte('\n')
+		binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	} else {
+		buf.WriteByte('=')
+	}
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// journalFieldName converts key into a valid journal field name, which
+// consists of upper case letters, digits and underscores and must not
+// begin with an underscore or digit.
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+	name = strings.TrimLeft(name, "_0123456789")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
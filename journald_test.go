@@ -0,0 +1,62 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func Test_Journal_SendsNativeProtocolFields(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phoenix-journal")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := path.Join(dir, "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	options, _ := newLogOptions("test", "1.2.3", nil)
+	options.journalSocket = socket
+	sink, err := openLogSink("journald", options)
+	if err != nil {
+		t.Fatalf("Unexpected error creating journal sink: %v", err)
+	}
+	defer sink.Close()
+
+	record := &logRecord{time.Now(), logLevelWarning, "test", "two\nlines", []logField{{"request.id", "42"}}}
+	if err := sink.writeRecord(record, logFormatText); err != nil {
+		t.Fatalf("Unexpected error writing record: %v", err)
+	}
+
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to receive datagram: %v", err)
+	}
+
+	length := make([]byte, 8)
+	binary.LittleEndian.PutUint64(length, uint64(len("two\nlines")))
+	expected := "MESSAGE\n" + string(length) + "two\nlines\n" +
+		"PRIORITY=4\n" +
+		"SYSLOG_IDENTIFIER=test\n" +
+		"VERSION=1.2.3\n" +
+		"REQUEST_ID=42\n"
+	if !bytes.Equal(buf[:n], []byte(expected)) {
+		t.Errorf("Expected datagram %q, but got %q", expected, buf[:n])
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logThresholds tracks the minimum level logged for each component. The
+// empty component provides the default for all components without a
+// level of their own.
+//
+// Levels changed at runtime may be reverted to their configured value
+// automatically after a timeout.
+type logThresholds struct {
+	sync.RWMutex
+	configured map[string]logLevel
+	levels     map[string]logLevel
+	reverts    map[string]*time.Timer
+}
+
+func newLogThresholds(configured map[string]logLevel) *logThresholds {
+	thresholds := &logThresholds{
+		configured: configured,
+		levels:     make(map[string]logLevel, len(configured)),
+		reverts:    make(map[string]*time.Timer),
+	}
+	for component, level := range configured {
+		thresholds.levels[component] = level
+	}
+	return thresholds
+}
+
+func (thresholds *logThresholds) enabled(component string, level logLevel) bool {
+	thresholds.RLock()
+	defer thresholds.RUnlock()
+
+	threshold, ok := thresholds.levels[component]
+	if !ok {
+		threshold = thresholds.levels[""]
+	}
+	return level >= threshold
+}
+
+// set changes the level of component, reverting it to the configured
+// level after the given duration unless it is zero.
+func (thresholds *logThresholds) set(component string, level logLevel, revert time.Duration) {
+	thresholds.Lock()
+	defer thresholds.Unlock()
+
+	thresholds.levels[component] = level
+	if timer, ok := thresholds.reverts[component]; ok {
+		timer.Stop()
+		delete(thresholds.reverts, component)
+	}
+
+	if revert > 0 {
+		var timer *time.Timer
+		timer = time.AfterFunc(revert, func() {
+			thresholds.Lock()
+			defer thresholds.Unlock()
+			if thresholds.reverts[component] == timer {
+				thresholds.reset(component)
+			}
+		})
+		thresholds.reverts[component] = timer
+	}
+}
+
+// reset restores the configured level of component, it must be called
+// with the lock held.
+func (thresholds *logThresholds) reset(component string) {
+	if timer, ok := thresholds.reverts[component]; ok {
+		timer.Stop()
+		delete(thresholds.reverts, component)
+	}
+	if level, ok := thresholds.configured[component]; ok {
+		thresholds.levels[component] = level
+	} else {
+		delete(thresholds.levels, component)
+	}
+}
+
+// toggleDebug switches the default level to debug, or back to its
+// configured level if debug logging is already enabled. If debug is the
+// configured level, it switches to info instead, which is not reverted.
+// It returns the new default level.
+func (thresholds *logThresholds) toggleDebug(revert time.Duration) logLevel {
+	thresholds.Lock()
+	if thresholds.levels[""] != logLevelDebug {
+		thresholds.Unlock()
+		thresholds.set("", logLevelDebug, revert)
+		return logLevelDebug
+	}
+	if thresholds.configured[""] != logLevelDebug {
+		thresholds.reset("")
+		level := thresholds.levels[""]
+		thresholds.Unlock()
+		return level
+	}
+	thresholds.Unlock()
+
+	thresholds.set("", logLevelInfo, 0)
+	return logLevelInfo
+}
+
+// snapshot returns the current level of every component by name.
+func (thresholds *logThresholds) snapshot() map[string]string {
+	thresholds.RLock()
+	defer thresholds.RUnlock()
+
+	levels := make(map[string]string, len(thresholds.levels))
+	for component, level := range thresholds.levels {
+		levels[component] = level.String()
+	}
+	return levels
+}
+
+// parseComponentLevels reads the default level from the [log] level
+// option and per component levels from options like level.<component>.
+func parseComponentLevels(config *config) (map[string]logLevel, error) {
+	levels := map[string]logLevel{"": logLevelDebug}
+	if config == nil {
+		return levels, nil
+	}
+
+	options, _ := config.GetOptions("log")
+	for _, option := range options {
+		component := ""
+		if option != "level" {
+			if !strings.HasPrefix(option, "level.") {
+				continue
+			}
+			component = strings.TrimPrefix(option, "level.")
+		}
+
+		value, _ := config.GetString("log", option)
+		level, err := parseLogLevel(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid [log] %s: %v", option, err)
+		}
+		levels[component] = level
+	}
+	return levels, nil
+}
+
+// componentLogger logs on behalf of a named component, whose messages
+// are subject to the component's level.
+type componentLogger struct {
+	dispatcher *logDispatcher
+	component  string
+}
+
+func (logger *componentLogger) logTarget() (*logDispatcher, string) {
+	return logger.dispatcher, logger.component
+}
+
+func (logger *componentLogger) Print(v ...interface{}) {
+	logger.dispatcher.logComponent(logger.component, logLevelInfo, fmt.Sprint(v...))
+}
+
+func (logger *componentLogger) Printf(format string, v ...interface{}) {
+	logger.dispatcher.logf(logger.component, logLevelInfo, format, v)
+}
+
+func (logger *componentLogger) Debugf(format string, v ...interface{}) {
+	logger.dispatcher.logf(logger.component, logLevelDebug, format, v)
+}
+
+func (logger *componentLogger) Infof(format string, v ...interface{}) {
+	logger.dispatcher.logf(logger.component, logLevelInfo, format, v)
+}
+
+func (logger *componentLogger) Warningf(format string, v ...interface{}) {
+	logger.dispatcher.logf(logger.component, logLevelWarning, format, v)
+}
+
+func (logger *componentLogger) Errorf(format string, v ...interface{}) {
+	logger.dispatcher.logf(logger.component, logLevelError, format, v)
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_LogThresholds_ComponentsDefaultToTheRootLevel(t *testing.T) {
+	thresholds := newLogThresholds(map[string]logLevel{"": logLevelWarning, "http": logLevelDebug})
+
+	if thresholds.enabled("db", logLevelInfo) {
+		t.Errorf("Expected info messages of an unconfigured component to be dropped")
+	}
+	if !thresholds.enabled("http", logLevelDebug) {
+		t.Errorf("Expected debug messages of the http component to be logged")
+	}
+}
+
+func Test_LogThresholds_RevertToTheConfiguredLevel(t *testing.T) {
+	thresholds := newLogThresholds(map[string]logLevel{"": logLevelError})
+
+	thresholds.set("db", logLevelDebug, 10*time.Millisecond)
+	if !thresholds.enabled("db", logLevelDebug) {
+		t.Fatalf("Expected debug messages to be logged after changing the level")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if thresholds.enabled("db", logLevelWarning) {
+		t.Errorf("Expected the level to be reverted to the default after the timeout")
+	}
+}
+
+func Test_LogThresholds_ToggleDebug(t *testing.T) {
+	thresholds := newLogThresholds(map[string]logLevel{"": logLevelInfo})
+
+	if level := thresholds.toggleDebug(0); level != logLevelDebug {
+		t.Errorf("Expected the first toggle to enable debug logging, but got %s", level)
+	}
+	if level := thresholds.toggleDebug(0); level != logLevelInfo {
+		t.Errorf("Expected the second toggle to restore the info level, but got %s", level)
+	}
+
+	thresholds = newLogThresholds(map[string]logLevel{"": logLevelDebug})
+	if level := thresholds.toggleDebug(0); level != logLevelInfo {
+		t.Errorf("Expected the first toggle to disable configured debug logging, but got %s", level)
+	}
+	if level := thresholds.toggleDebug(0); level != logLevelDebug {
+		t.Errorf("Expected the second toggle to restore debug logging, but got %s", level)
+	}
+}
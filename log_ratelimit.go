@@ -0,0 +1,89 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// logRateLimiter restricts how often a message template may be logged
+// within each interval. Suppressed messages are counted, so that a
+// summary can be logged once the interval is over.
+type logRateLimiter struct {
+	sync.Mutex
+	limit    int
+	interval time.Duration
+	counts   map[string]*logRateCount
+}
+
+type logRateCount struct {
+	component  string
+	level      logLevel
+	template   string
+	logged     int
+	suppressed int
+}
+
+// newLogRateLimiter returns a limiter allowing limit messages per template
+// and interval, or nil if limit is not positive.
+func newLogRateLimiter(limit int, interval time.Duration) *logRateLimiter {
+	if limit <= 0 || interval <= 0 {
+		return nil
+	}
+	return &logRateLimiter{
+		limit:    limit,
+		interval: interval,
+		counts:   make(map[string]*logRateCount),
+	}
+}
+
+// allow reports whether a message with the given template may be logged.
+func (limiter *logRateLimiter) allow(component string, level logLevel, template string) bool {
+	key := component + "\x00" + template
+
+	limiter.Lock()
+	defer limiter.Unlock()
+
+	count, ok := limiter.counts[key]
+	if !ok {
+		count = &logRateCount{component: component, level: level, template: template}
+		limiter.counts[key] = count
+	}
+	if count.logged >= limiter.limit {
+		count.suppressed++
+		return false
+	}
+	count.logged++
+	return true
+}
+
+// reset starts a new interval, returning the counts of all templates
+// which had messages suppressed during the last one.
+func (limiter *logRateLimiter) reset() (suppressed []*logRateCount) {
+	limiter.Lock()
+	defer limiter.Unlock()
+
+	for _, count := range limiter.counts {
+		if count.suppressed > 0 {
+			suppressed = append(suppressed, count)
+		}
+	}
+	limiter.counts = make(map[string]*logRateCount)
+	return
+}
+
+// formatCount formats n using commas as thousands separators.
+func formatCount(n int) string {
+	digits := strconv.Itoa(n)
+	if n < 0 {
+		return "-" + formatCount(-n)
+	}
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + "," + digits[i:]
+	}
+	return digits
+}
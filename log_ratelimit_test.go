@@ -0,0 +1,101 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_LogRateLimiter_SuppressesMessagesOverTheLimit(t *testing.T) {
+	limiter := newLogRateLimiter(2, time.Minute)
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if limiter.allow("", logLevelError, "downstream failed: %v") {
+			allowed++
+		}
+	}
+	if !limiter.allow("", logLevelError, "another template") {
+		t.Errorf("Expected a different template to have its own limit")
+	}
+	if allowed != 2 {
+		t.Errorf("Expected 2 messages to be allowed, but got %d", allowed)
+	}
+
+	suppressed := limiter.reset()
+	if len(suppressed) != 1 || suppressed[0].suppressed != 8 {
+		t.Fatalf("Expected one template with 8 suppressed messages, but got %v", suppressed)
+	}
+	if !limiter.allow("", logLevelError, "downstream failed: %v") {
+		t.Errorf("Expected messages to be allowed again in the next interval")
+	}
+}
+
+func Test_LogDispatcher_RateLimitsGenericFormatsByMessage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phoenix-log")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	logPath := path.Join(dir, "app.log")
+	options, _ := newLogOptions("test", "", nil)
+	sinks, err := openLogSinks("file:"+logPath, options)
+	if err != nil {
+		t.Fatalf("Unexpected error opening log sinks: %v", err)
+	}
+	dispatcher := newLogDispatcher("test", sinks, newLogThresholds(options.levels), newLogRateLimiter(1, time.Minute))
+
+	dispatcher.logf("", logLevelError, "%v", []interface{}{"disk full"})
+	dispatcher.logf("", logLevelError, "%v", []interface{}{"disk full"})
+	dispatcher.logf("", logLevelError, "%v", []interface{}{"shutting down"})
+	dispatcher.logf("", logLevelError, "failed: %v", []interface{}{"a"})
+	dispatcher.logf("", logLevelError, "failed: %v", []interface{}{"b"})
+	dispatcher.logUnlimited(logLevelError, "shutting down")
+	dispatcher.Close()
+
+	text, _ := ioutil.ReadFile(logPath)
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSpace(string(text)), "\n") {
+		// Strip the name and timestamp.
+		messages = append(messages, strings.SplitN(line, " ", 4)[3])
+	}
+	if len(messages) > 4 {
+		// Summaries are logged in no particular order.
+		sort.Strings(messages[4:])
+	}
+	expected := []string{
+		"disk full", "shutting down", "failed: a", "shutting down",
+		"message repeated 1 times: disk full", "message repeated 1 times: failed: %v",
+	}
+	if strings.Join(messages, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected messages %q, but got %q", expected, messages)
+	}
+}
+
+func Test_HasLiteralText_IgnoresVerbs(t *testing.T) {
+	for format, expected := range map[string]bool{
+		"%v": false, "%v\n%s": false, "%s: %-10.3f": false, "%[1]d%%": false,
+		"failed: %v": true, "%d items": true,
+	} {
+		if actual := hasLiteralText(format); actual != expected {
+			t.Errorf("Expected hasLiteralText(%q) to be %v", format, expected)
+		}
+	}
+}
+
+func Test_FormatCount_UsesThousandsSeparators(t *testing.T) {
+	for n, expected := range map[int]string{7: "7", 4512: "4,512", 1234567: "1,234,567", -1000: "-1,000"} {
+		if actual := formatCount(n); actual != expected {
+			t.Errorf("Expected %d to be formatted as '%s', but was '%s'", n, expected, actual)
+		}
+	}
+}
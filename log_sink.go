@@ -0,0 +1,331 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// logSinkBufferSize is the number of records which may be queued for a
+// single sink before further records are dropped.
+const logSinkBufferSize = 1024
+
+// logSink is a destination for log records.
+type logSink interface {
+	writeRecord(record *logRecord, format logFormat) error
+	Close() error
+}
+
+// outputSink writes the records passing its level to a logSink in its
+// format. Records for sinks which may block, such as syslog and journald,
+// are queued, so that a slow or failing destination never blocks any of
+// the others. Files and stderr are written synchronously, so that nothing
+// logged right before the process exits is lost.
+type outputSink struct {
+	description string
+	sink        logSink
+	level       logLevel
+	format      logFormat
+	records     chan *logRecord
+	done        chan struct{}
+	dropped     uint64
+	failing     int32
+}
+
+func newOutputSink(description string, sink logSink, level logLevel, format logFormat) *outputSink {
+	output := &outputSink{
+		description: description,
+		sink:        sink,
+		level:       level,
+		format:      format,
+	}
+	switch sink.(type) {
+	case *writerSink, *fileSink:
+	default:
+		output.records = make(chan *logRecord, logSinkBufferSize)
+		output.done = make(chan struct{})
+		go output.run()
+	}
+	return output
+}
+
+func (output *outputSink) enqueue(record *logRecord) {
+	if record.level < output.level {
+		return
+	}
+	if output.records == nil {
+		output.write(record)
+		return
+	}
+
+	select {
+	case output.records <- record:
+	default:
+		atomic.AddUint64(&output.dropped, 1)
+	}
+}
+
+func (output *outputSink) run() {
+	defer close(output.done)
+
+	for record := range output.records {
+		if dropped := atomic.SwapUint64(&output.dropped, 0); dropped > 0 {
+			notice := *record
+			notice.level = logLevelWarning
+			notice.message = fmt.Sprintf("%d log messages were dropped", dropped)
+			output.sink.writeRecord(&notice, output.format)
+		}
+		output.write(record)
+	}
+}
+
+// write writes record to the sink, complaining on stderr once it starts
+// failing, as that is the only place left to complain to.
+func (output *outputSink) write(record *logRecord) {
+	if err := output.sink.writeRecord(record, output.format); err != nil {
+		if atomic.SwapInt32(&output.failing, 1) == 0 {
+			fmt.Fprintf(os.Stderr, "log output %s is failing: %v\n", output.description, err)
+		}
+	} else {
+		atomic.StoreInt32(&output.failing, 0)
+	}
+}
+
+// Close flushes all queued records and closes the underlying sink.
+func (output *outputSink) Close() error {
+	if output.records != nil {
+		close(output.records)
+		<-output.done
+	}
+	return output.sink.Close()
+}
+
+// logReopener is implemented by sinks which can reopen their destination.
+type logReopener interface {
+	reopen() error
+}
+
+// writerSink writes formatted records to stderr.
+type writerSink struct {
+	io.WriteCloser
+}
+
+func (sink *writerSink) writeRecord(record *logRecord, format logFormat) error {
+	_, err := sink.Write(record.format(format, false))
+	return err
+}
+
+// fileSink writes formatted records to a file, which may be reopened
+// after it has been rotated.
+type fileSink struct {
+	sync.Mutex
+	path string
+	file io.WriteCloser
+}
+
+func newFileSink(path string) (*fileSink, error) {
+	file, err := openLogWriter(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{path: path, file: file}, nil
+}
+
+func (sink *fileSink) writeRecord(record *logRecord, format logFormat) error {
+	sink.Lock()
+	defer sink.Unlock()
+	_, err := sink.file.Write(record.format(format, false))
+	return err
+}
+
+func (sink *fileSink) reopen() error {
+	file, err := openLogWriter(sink.path)
+	if err != nil {
+		return err
+	}
+
+	sink.Lock()
+	previous := sink.file
+	sink.file = file
+	sink.Unlock()
+	return previous.Close()
+}
+
+func (sink *fileSink) Close() error {
+	sink.Lock()
+	defer sink.Unlock()
+	return sink.file.Close()
+}
+
+// syslogSink writes records to the local syslog daemon.
+type syslogSink struct {
+	*syslog.Writer
+}
+
+func newSyslogSink(options *syslogOptions) (logSink, error) {
+	writer, err := syslog.New(syslog.LOG_INFO|options.facility, options.tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{writer}, nil
+}
+
+func (sink *syslogSink) writeRecord(record *logRecord, format logFormat) error {
+	// Syslog automatically adds the tag and a timestamp.
+	message := string(record.format(format, true))
+	switch record.level {
+	case logLevelError:
+		return sink.Err(message)
+	case logLevelWarning:
+		return sink.Warning(message)
+	case logLevelInfo:
+		return sink.Info(message)
+	}
+	return sink.Debug(message)
+}
+
+// logOptions holds the [log] settings shared by all outputs.
+type logOptions struct {
+	name, version string
+	levels        map[string]logLevel
+	format        logFormat
+	syslog        *syslogOptions
+	journalSocket string
+	rateLimit     int
+	rateInterval  time.Duration
+}
+
+func newLogOptions(name, version string, config *config) (options *logOptions, err error) {
+	options = &logOptions{
+		name:          name,
+		version:       version,
+		format:        logFormatText,
+		journalSocket: journalDefaultSocket,
+	}
+	if options.levels, err = parseComponentLevels(config); err != nil {
+		return nil, err
+	}
+	if config != nil {
+		options.journalSocket = config.GetStringDefault("log", "journalsocket", journalDefaultSocket)
+		// Allow at most ratelimit messages per template in rateinterval seconds.
+		options.rateLimit = config.GetIntDefault("log", "ratelimit", 0)
+		options.rateInterval = time.Duration(config.GetIntDefault("log", "rateinterval", 10)) * time.Second
+		if options.format, err = parseLogFormat(config.GetStringDefault("log", "format", "text")); err != nil {
+			return nil, err
+		}
+	}
+	if options.syslog, err = newSyslogOptions(name, config); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// openLogSinks creates the sinks described by outputs, a space separated
+// list of destinations. Each destination is one of "stderr", "syslog",
+// "journald" or "file:<path>", and may be followed by query style options, e.g.
+//
+//	stderr?level=info file:/var/log/app.log?format=json&level=debug
+//
+// Destinations without a level accept every record which passes the level
+// of its component, destinations without a format use the configured one.
+func openLogSinks(outputs string, options *logOptions) (sinks []*outputSink, err error) {
+	defer func() {
+		if err != nil {
+			for _, sink := range sinks {
+				sink.Close()
+			}
+			sinks = nil
+		}
+	}()
+
+	for _, output := range strings.Fields(outputs) {
+		destination, rawOptions := output, ""
+		if i := strings.LastIndex(output, "?"); i >= 0 {
+			destination, rawOptions = output[:i], output[i+1:]
+		}
+
+		query, err := url.ParseQuery(rawOptions)
+		if err != nil {
+			return sinks, fmt.Errorf("invalid options for log output '%s': %v", output, err)
+		}
+
+		level := logLevelDebug
+		if value := query.Get("level"); value != "" {
+			if level, err = parseLogLevel(value); err != nil {
+				return sinks, err
+			}
+		}
+
+		format := options.format
+		if value := query.Get("format"); value != "" {
+			if format, err = parseLogFormat(value); err != nil {
+				return sinks, err
+			}
+		}
+
+		sink, err := openLogSink(destination, options)
+		if err != nil {
+			return sinks, err
+		}
+		sinks = append(sinks, newOutputSink(destination, sink, level, format))
+	}
+
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("no log outputs were configured")
+	}
+	return sinks, nil
+}
+
+func openLogSink(destination string, options *logOptions) (logSink, error) {
+	switch {
+	case destination == "stderr":
+		wc, err := openLogWriter("")
+		return &writerSink{wc}, err
+	case destination == "syslog":
+		if options.syslog.address != "" {
+			return newRemoteSyslogSink(options.version, options.syslog)
+		}
+		return newSyslogSink(options.syslog)
+	case destination == "journald":
+		return newJournalSink(options), nil
+	case strings.HasPrefix(destination, "file:"):
+		logfile := strings.TrimPrefix(destination, "file:")
+		if logfile == "" {
+			return nil, fmt.Errorf("log output '%s' is missing a path", destination)
+		}
+		return newFileSink(logfile)
+	}
+	return nil, fmt.Errorf("unknown log output '%s'", destination)
+}
+
+// openLegacyLogSink creates a sink for a single logfile setting, which
+// is either empty for stderr, "syslog", "journald" or the path of a file.
+func openLegacyLogSink(logfile string, options *logOptions) (*outputSink, error) {
+	return openSingleLogSink(logfile, options, options.format)
+}
+
+func openSingleLogSink(logfile string, options *logOptions, format logFormat) (*outputSink, error) {
+	destination := "file:" + logfile
+	switch logfile {
+	case "":
+		destination = "stderr"
+	case "syslog", "journald":
+		destination = logfile
+	}
+
+	sink, err := openLogSink(destination, options)
+	if err != nil {
+		return nil, err
+	}
+	return newOutputSink(destination, sink, logLevelDebug, format), nil
+}
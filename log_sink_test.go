@@ -0,0 +1,113 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func Test_LogSinks_EachOutputAppliesItsOwnLevelAndFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phoenix-log")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	textPath, jsonPath := path.Join(dir, "text.log"), path.Join(dir, "json.log")
+	outputs := "file:" + textPath + " file:" + jsonPath + "?level=warning&format=json"
+	options, _ := newLogOptions("test", "", nil)
+	sinks, err := openLogSinks(outputs, options)
+	if err != nil {
+		t.Fatalf("Unexpected error opening log sinks: %v", err)
+	}
+
+	dispatcher := newLogDispatcher("test", sinks, newLogThresholds(options.levels), nil)
+	dispatcher.log(logLevelDebug, "debug message")
+	dispatcher.log(logLevelWarning, "warning message")
+	if err := dispatcher.Close(); err != nil {
+		t.Fatalf("Unexpected error closing log sinks: %v", err)
+	}
+
+	text, _ := ioutil.ReadFile(textPath)
+	if lines := strings.Split(strings.TrimSpace(string(text)), "\n"); len(lines) != 2 {
+		t.Errorf("Expected 2 lines in the text log, but got %d: %q", len(lines), text)
+	} else if !strings.HasPrefix(lines[0], "test ") || !strings.HasSuffix(lines[0], " debug message") {
+		t.Errorf("Unexpected text log line '%s'", lines[0])
+	}
+
+	data, _ := ioutil.ReadFile(jsonPath)
+	var entry map[string]string
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("Expected a single JSON entry, but got %q: %v", data, err)
+	}
+	if entry["level"] != "warning" || entry["msg"] != "warning message" {
+		t.Errorf("Unexpected JSON log entry %v", entry)
+	}
+}
+
+func Test_LogSinks_RejectsUnknownOutputs(t *testing.T) {
+	options, _ := newLogOptions("test", "", nil)
+	if _, err := openLogSinks("stderr carrier-pigeon", options); err == nil {
+		t.Errorf("Expected an error for an unknown log output")
+	}
+}
+
+func Test_LogSinks_WritesFilesSynchronously(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phoenix-log")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	logPath := path.Join(dir, "app.log")
+	options, _ := newLogOptions("test", "", nil)
+	sinks, err := openLogSinks("file:"+logPath, options)
+	if err != nil {
+		t.Fatalf("Unexpected error opening log sinks: %v", err)
+	}
+	dispatcher := newLogDispatcher("test", sinks, newLogThresholds(options.levels), nil)
+	defer dispatcher.Close()
+
+	dispatcher.log(logLevelError, "last words")
+	if text, _ := ioutil.ReadFile(logPath); !strings.Contains(string(text), "last words") {
+		t.Errorf("Expected the message to be written before log returns, but got %q", text)
+	}
+}
+
+func Test_LogSinks_LogsUntypedMessagesAtInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phoenix-log")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	logPath := path.Join(dir, "app.log")
+	options, _ := newLogOptions("test", "", nil)
+	sinks, err := openLogSinks("file:"+logPath+"?format=json", options)
+	if err != nil {
+		t.Fatalf("Unexpected error opening log sinks: %v", err)
+	}
+	dispatcher := newLogDispatcher("test", sinks, newLogThresholds(options.levels), nil)
+	(&componentLogger{dispatcher, "db"}).Printf("printed %d", 1)
+	dispatcher.Write([]byte("written through the log package\n"))
+	dispatcher.Close()
+
+	data, _ := ioutil.ReadFile(logPath)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 entries, but got %q", data)
+	}
+	for _, line := range lines {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil || entry["level"] != "info" {
+			t.Errorf("Expected an entry at the info level, but got %q", line)
+		}
+	}
+}
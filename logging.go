@@ -5,24 +5,360 @@
 package phoenix
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"path"
+	"strings"
 	"sync"
+	"time"
 )
 
+type logLevel int
+
+const (
+	logLevelDebug logLevel = iota
+	logLevelInfo
+	logLevelWarning
+	logLevelError
+)
+
+var logLevelNames = []string{"debug", "info", "warning", "error"}
+
+func parseLogLevel(name string) (logLevel, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "warn" {
+		name = "warning"
+	}
+	for level, levelName := range logLevelNames {
+		if name == levelName {
+			return logLevel(level), nil
+		}
+	}
+	return logLevelDebug, fmt.Errorf("unknown log level '%s'", name)
+}
+
+func (level logLevel) String() string {
+	if level < logLevelDebug || int(level) >= len(logLevelNames) {
+		return fmt.Sprintf("level(%d)", int(level))
+	}
+	return logLevelNames[level]
+}
+
+type logFormat int
+
+const (
+	logFormatText logFormat = iota
+	logFormatJSON
+	// logFormatRaw writes messages as they are, for preformatted logs.
+	logFormatRaw
+)
+
+func parseLogFormat(name string) (logFormat, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "text":
+		return logFormatText, nil
+	case "json":
+		return logFormatJSON, nil
+	}
+	return logFormatText, fmt.Errorf("unknown log format '%s'", name)
+}
+
+// logField is a key value pair attached to a log record.
+type logField struct {
+	key, value string
+}
+
+// logRecord is a single log message on its way to the configured sinks.
+type logRecord struct {
+	time    time.Time
+	level   logLevel
+	name    string
+	message string
+	fields  []logField
+}
+
+// format renders the record. Text records match the historic output of
+// log.LstdFlags followed by the record's fields as key="value" pairs,
+// unless bare is set, in which case the header is left out for
+// destinations (like syslog) which add their own.
+func (record *logRecord) format(format logFormat, bare bool) []byte {
+	return record.render(format, bare, true)
+}
+
+// formatMessage renders the record like a bare format, but leaves out the
+// fields of text records, for destinations which carry them separately.
+func (record *logRecord) formatMessage(format logFormat) []byte {
+	return record.render(format, true, false)
+}
+
+func (record *logRecord) render(format logFormat, bare, fields bool) []byte {
+	if format == logFormatRaw {
+		if bare {
+			return []byte(record.message)
+		}
+		return []byte(record.message + "\n")
+	}
+
+	if format == logFormatJSON {
+		var fields map[string]string
+		if len(record.fields) > 0 {
+			fields = make(map[string]string, len(record.fields))
+			for _, field := range record.fields {
+				fields[field.key] = field.value
+			}
+		}
+		data, err := json.Marshal(&struct {
+			Time    string            `json:"time"`
+			Level   string            `json:"level"`
+			Name    string            `json:"name,omitempty"`
+			Message string            `json:"msg"`
+			Fields  map[string]string `json:"fields,omitempty"`
+		}{
+			record.time.Format(time.RFC3339Nano),
+			record.level.String(),
+			record.name,
+			record.message,
+			fields,
+		})
+		if err != nil {
+			data = []byte(fmt.Sprintf("%q", record.message))
+		}
+		return append(data, '\n')
+	}
+
+	buf := &bytes.Buffer{}
+	if !bare {
+		if record.name != "" {
+			buf.WriteString(record.name)
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(record.time.Format("2006/01/02 15:04:05 "))
+	}
+	buf.WriteString(record.message)
+	if fields {
+		for _, field := range record.fields {
+			fmt.Fprintf(buf, " %s=%q", field.key, field.value)
+		}
+	}
+	if !bare {
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// logTarget is implemented by loggers which log through a dispatcher on
+// behalf of a component.
+type logTarget interface {
+	logTarget() (*logDispatcher, string)
+}
+
+// logDispatcher fans log records out to all configured sinks, dropping
+// those below the level of the component which logged them and those
+// exceeding the rate limit.
+//
+// It implements io.Writer so that it may back a log.Logger, text written
+// that way is logged at the info level.
+type logDispatcher struct {
+	sync.RWMutex
+	name       string
+	sinks      []*outputSink
+	thresholds *logThresholds
+	limiter    *logRateLimiter
+	stop       chan struct{}
+	stopped    chan struct{}
+	closeOnce  sync.Once
+	closed     bool
+}
+
+func newLogDispatcher(name string, sinks []*outputSink, thresholds *logThresholds, limiter *logRateLimiter) *logDispatcher {
+	dispatcher := &logDispatcher{
+		name:       name,
+		sinks:      sinks,
+		thresholds: thresholds,
+		limiter:    limiter,
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	go dispatcher.summarize()
+	return dispatcher
+}
+
+func (dispatcher *logDispatcher) Write(p []byte) (int, error) {
+	dispatcher.log(logLevelInfo, string(bytes.TrimSuffix(p, []byte{'\n'})))
+	return len(p), nil
+}
+
+func (dispatcher *logDispatcher) log(level logLevel, message string) {
+	dispatcher.logComponent("", level, message)
+}
+
+// logComponent logs message on behalf of component. Identical messages
+// count towards the same rate limit.
+func (dispatcher *logDispatcher) logComponent(component string, level logLevel, message string) {
+	if !dispatcher.allow(component, level, message) {
+		return
+	}
+	dispatcher.dispatch(dispatcher.newRecord(component, level, message))
+}
+
+// logf formats and logs a message on behalf of component. Messages using
+// the same format count towards the same rate limit, unless the format
+// has no text of its own, like "%v", in which case identical messages do.
+func (dispatcher *logDispatcher) logf(component string, level logLevel, format string, v []interface{}) {
+	message := fmt.Sprintf(format, v...)
+	template := format
+	if !hasLiteralText(format) {
+		template = message
+	}
+	if !dispatcher.allow(component, level, template) {
+		return
+	}
+	dispatcher.dispatch(dispatcher.newRecord(component, level, message))
+}
+
+// logUnlimited logs message regardless of the rate limit, for messages
+// which must never be suppressed, like crash reports.
+func (dispatcher *logDispatcher) logUnlimited(level logLevel, message string) {
+	if !dispatcher.thresholds.enabled("", level) {
+		return
+	}
+	dispatcher.dispatch(dispatcher.newRecord("", level, message))
+}
+
+// logFields logs message with additional structured fields on behalf of
+// component. Identical messages count towards the same rate limit.
+func (dispatcher *logDispatcher) logFields(component string, level logLevel, message string, fields []logField) {
+	if !dispatcher.allow(component, level, message) {
+		return
+	}
+	record := dispatcher.newRecord(component, level, message)
+	record.fields = append(record.fields, fields...)
+	dispatcher.dispatch(record)
+}
+
+// hasLiteralText reports whether format contains any letters besides its
+// verbs, which tell messages using it apart from those using other formats.
+func hasLiteralText(format string) bool {
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c == '%' {
+			// Skip flags, width and precision up to the verb.
+			for i++; i < len(format) && strings.IndexByte("+-# 0123456789.*[]", format[i]) >= 0; i++ {
+			}
+			continue
+		}
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			return true
+		}
+	}
+	return false
+}
+
+func (dispatcher *logDispatcher) allow(component string, level logLevel, template string) bool {
+	if !dispatcher.thresholds.enabled(component, level) {
+		return false
+	}
+	return dispatcher.limiter == nil || dispatcher.limiter.allow(component, level, template)
+}
+
+func (dispatcher *logDispatcher) newRecord(component string, level logLevel, message string) *logRecord {
+	var fields []logField
+	if component != "" {
+		fields = []logField{{"component", component}}
+	}
+	return &logRecord{time.Now(), level, dispatcher.name, message, fields}
+}
+
+func (dispatcher *logDispatcher) dispatch(record *logRecord) {
+	dispatcher.RLock()
+	defer dispatcher.RUnlock()
+	if dispatcher.closed {
+		return
+	}
+	for _, sink := range dispatcher.sinks {
+		sink.enqueue(record)
+	}
+}
+
+// summarize logs how often rate limited messages were suppressed at the
+// end of every interval, and once more when the dispatcher is closed.
+func (dispatcher *logDispatcher) summarize() {
+	defer close(dispatcher.stopped)
+	if dispatcher.limiter == nil {
+		return
+	}
+
+	ticker := time.NewTicker(dispatcher.limiter.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-dispatcher.stop:
+			dispatcher.logSuppressed()
+			return
+		}
+		dispatcher.logSuppressed()
+	}
+}
+
+func (dispatcher *logDispatcher) logSuppressed() {
+	for _, count := range dispatcher.limiter.reset() {
+		message := fmt.Sprintf("message repeated %s times: %s", formatCount(count.suppressed), count.template)
+		dispatcher.dispatch(dispatcher.newRecord(count.component, count.level, message))
+	}
+}
+
+// reopen reopens all sinks writing to files, so that they pick up files
+// which were moved away for rotation.
+func (dispatcher *logDispatcher) reopen() error {
+	faults := &multiError{}
+	for _, sink := range dispatcher.sinks {
+		if reopener, ok := sink.sink.(logReopener); ok {
+			faults.AddError(reopener.reopen())
+		}
+	}
+	return faults.AsError()
+}
+
+// Close flushes all pending records and closes every sink.
+func (dispatcher *logDispatcher) Close() (err error) {
+	dispatcher.closeOnce.Do(func() {
+		close(dispatcher.stop)
+		<-dispatcher.stopped
+
+		dispatcher.Lock()
+		dispatcher.closed = true
+		dispatcher.Unlock()
+
+		faults := &multiError{}
+		for _, sink := range dispatcher.sinks {
+			faults.AddError(sink.Close())
+		}
+		err = faults.AsError()
+	})
+	return
+}
+
 func makeLogger(name string, w io.Writer) *log.Logger {
 	return log.New(w, name+" ", log.LstdFlags)
 }
 
-func setSystemLogger(name string, w io.Writer) {
+// setSystemLogger points the core logging package at w, leaving the
+// formatting to the sinks behind w. The returned function restores the
+// previous settings.
+func setSystemLogger(w io.Writer) (restore func()) {
+	output, prefix, flags := log.Writer(), log.Prefix(), log.Flags()
 	log.SetOutput(w)
-	if name != "" {
-		log.SetPrefix(name + " ")
-		log.SetFlags(log.LstdFlags)
-	} else {
-		log.SetFlags(log.LstdFlags &^ (log.Ldate | log.Ltime))
+	log.SetPrefix("")
+	log.SetFlags(0)
+	return func() {
+		log.SetOutput(output)
+		log.SetPrefix(prefix)
+		log.SetFlags(flags)
 	}
 }
 
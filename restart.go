@@ -0,0 +1,112 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RestartPolicy determines whether a service is restarted once its Start
+// method returns.
+type RestartPolicy int
+
+const (
+	// RestartNever leaves services stopped once they returned.
+	RestartNever RestartPolicy = iota
+	// RestartOnFailure restarts services which returned an error.
+	RestartOnFailure
+	// RestartAlways restarts services whenever they returned.
+	RestartAlways
+)
+
+var restartPolicyNames = []string{"never", "on-failure", "always"}
+
+func (policy RestartPolicy) String() string {
+	if policy < RestartNever || int(policy) >= len(restartPolicyNames) {
+		return fmt.Sprintf("RestartPolicy(%d)", int(policy))
+	}
+	return restartPolicyNames[policy]
+}
+
+func parseRestartPolicy(name string) (RestartPolicy, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i, policyName := range restartPolicyNames {
+		if name == policyName {
+			return RestartPolicy(i), nil
+		}
+	}
+	return RestartNever, fmt.Errorf("unknown restart policy '%s'", name)
+}
+
+// Supervised may be implemented by services to choose their restart
+// policy, overriding [runtime] restartpolicy.
+type Supervised interface {
+	RestartPolicy() RestartPolicy
+}
+
+// restarts reports whether a service which returned err should be started
+// again.
+func (policy RestartPolicy) restarts(err error) bool {
+	switch policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return err != nil
+	}
+	return false
+}
+
+// restartBackoff spaces restarts of a service exponentially and detects
+// crash loops, which are more than limit restarts within window.
+type restartBackoff struct {
+	delay, maxDelay, window time.Duration
+	limit                   int
+	restarts                []time.Time
+}
+
+func newRestartBackoff(config Config) *restartBackoff {
+	return &restartBackoff{
+		delay:    time.Duration(config.GetFloat64Default("runtime", "restartdelay", 1) * float64(time.Second)),
+		maxDelay: time.Duration(config.GetFloat64Default("runtime", "restartmaxdelay", 60) * float64(time.Second)),
+		window:   time.Duration(config.GetIntDefault("runtime", "restartwindow", 300)) * time.Second,
+		limit:    config.GetIntDefault("runtime", "restartlimit", 5),
+	}
+}
+
+// next records a restart at now and returns the time to wait before it,
+// which doubles with every restart within the window. It returns false if
+// the service is crash looping and should not be restarted.
+func (backoff *restartBackoff) next(now time.Time) (time.Duration, bool) {
+	recent := backoff.restarts[:0]
+	for _, restart := range backoff.restarts {
+		if now.Sub(restart) < backoff.window {
+			recent = append(recent, restart)
+		}
+	}
+	backoff.restarts = recent
+	if len(backoff.restarts) >= backoff.limit {
+		return 0, false
+	}
+
+	delay := backoff.delay
+	for i := 0; i < len(backoff.restarts) && delay < backoff.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > backoff.maxDelay {
+		delay = backoff.maxDelay
+	}
+	backoff.restarts = append(backoff.restarts, now)
+	return delay, true
+}
+
+// restartPolicy returns the restart policy of service.
+func (manager *serviceManager) restartPolicy(service *managedService) (RestartPolicy, error) {
+	if supervised, ok := service.impl.(Supervised); ok {
+		return supervised.RestartPolicy(), nil
+	}
+	return parseRestartPolicy(manager.GetStringDefault("runtime", "restartpolicy", "never"))
+}
@@ -6,12 +6,15 @@ package phoenix
 
 import (
 	"crypto/tls"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 )
 
 // Runtime provides application runtime support and
@@ -22,18 +25,40 @@ type Runtime interface {
 	// Service specifies a Service to be managed by this runtime.
 	Service(Service)
 
+	// ServiceAfter specifies a Service to be managed by this runtime, which
+	// will only be started once the given services are ready, and stopped
+	// before them. Services are identified by comparing them, so those
+	// given in after must be of a comparable type, preferably pointers.
+	// Services may also declare dependencies by name, see DependentService.
+	ServiceAfter(service Service, after ...Service)
+
+	// ContextService specifies a ContextService to be managed by this
+	// runtime.
+	ContextService(ContextService)
+
+	// AddService is like ServiceAfter, but reports invalid dependencies.
+	// Services added after Start has been called are started right away,
+	// and reloaded and stopped along with all other services.
+	AddService(service Service, after ...Service) error
+
+	// RemoveService stops the named service and removes it from the
+	// runtime. Services which others depend on cannot be removed.
+	RemoveService(name string) error
+
 	// DefaultHTTPHandler specifies a handler which will be run
 	// using the default HTTP server configuration.
 	//
-	// The results of calling this method after Start() has been
-	// called are undefined.
+	// Servers for handlers given after Start() has been called are
+	// started right away, errors setting them up are logged, as Start()
+	// can no longer return them.
 	DefaultHTTPHandler(http.Handler)
 
 	// DefaultHTTPSHandler specifies a handler which will be run
 	// using the default HTTPS server configuration.
 	//
-	// The results of calling this method after Start() has been
-	// called are undefined.
+	// Servers for handlers given after Start() has been called are
+	// started right away, errors setting them up are logged, as Start()
+	// can no longer return them.
 	DefaultHTTPSHandler(http.Handler)
 
 	// TLSConfig returns the current tls.Config used with HTTPS servers
@@ -50,8 +75,33 @@ type Runtime interface {
 	// was called.
 	SetTLSConfig(*tls.Config)
 
+	// SetLogLevel changes the minimum level logged by the named component,
+	// or by all components without a level of their own if component is
+	// empty. Levels are one of "debug", "info", "warning" and "error".
+	SetLogLevel(component, level string) error
+
+	// SetLogLevelFor is like SetLogLevel, but reverts the component to its
+	// configured level after the given duration.
+	SetLogLevelFor(component, level string, revert time.Duration) error
+
+	// OnCrash registers a handler which may add application specific
+	// context to crash reports, see the [runtime] crashdir option.
+	OnCrash(CrashHandler)
+
 	// Start runs all registered servers and blocks until they terminate.
 	Start() error
+
+	// Ready returns a channel which is closed once all services started
+	// by Start are ready, see ReadyNotifier.
+	Ready() <-chan struct{}
+
+	// Services returns the status of all registered services.
+	Services() []ServiceStatus
+
+	// Events subscribes to the state transitions of all services until
+	// the returned function is called. Events are dropped rather than
+	// blocking services if the subscriber falls behind.
+	Events() (<-chan ServiceEvent, func())
 }
 
 type startFunc func(Runtime) error
@@ -65,10 +115,21 @@ type callback struct {
 
 type runtime struct {
 	*serviceManager
-	callbacks []callback
-	tlsConfig *tls.Config
-	runFunc   RunFunc
-	rawLogger *log.Logger
+	callbacks     []callback
+	tlsConfig     *tls.Config
+	runFunc       RunFunc
+	rawLogger     *log.Logger
+	started       time.Time
+	crashHandlers []CrashHandler
+	notifier      *sdNotifier
+	// inherited holds the sockets passed by systemd or an upgrading
+	// process which haven't yet been claimed by a listen entry.
+	inherited map[string][]net.Listener
+	// upgradeNotifier reports readiness to the process upgraded from.
+	upgradeNotifier *sdNotifier
+	upgrading       int32
+	// draining is set once the runtime is about to stop.
+	draining int32
 }
 
 func newRuntime(container *container, runFunc RunFunc) *runtime {
@@ -78,6 +139,18 @@ func newRuntime(container *container, runFunc RunFunc) *runtime {
 		nil,
 		runFunc,
 		container.Logger,
+		time.Now(),
+		nil,
+		newSDNotifier("NOTIFY_SOCKET"),
+		nil,
+		newSDNotifier(upgradeNotifyVariable),
+		0,
+		0,
+	}
+	os.Unsetenv(upgradeNotifyVariable)
+	runtime.onPanic = runtime.reportCrash
+	runtime.shutdown = func() {
+		runtime.Stop()
 	}
 
 	return runtime
@@ -95,15 +168,56 @@ func (runtime *runtime) OnStop(stop stopFunc) {
 	runtime.Callback(func(_ Runtime) error { return nil }, stop)
 }
 
+func (runtime *runtime) OnCrash(handler CrashHandler) {
+	runtime.crashHandlers = append(runtime.crashHandlers, handler)
+}
+
 func (runtime *runtime) Run() (err error) {
 	defer func() {
 		if err != nil {
-			runtime.Print(err)
+			runtime.Errorf("%v", err)
 		}
 	}()
 
+	// Toggling debug logging is disabled by default, as existing
+	// deployments may rely on signals like SIGUSR1 terminating the process.
+	debugSignal, err := configSignal(runtime, "log", "debugsignal", "none")
+	if err != nil {
+		return
+	}
+	debugTimeout := time.Duration(runtime.GetIntDefault("log", "debugtimeout", 0)) * time.Second
+
+	// Dumping goroutines is disabled by default, as catching e.g. SIGQUIT
+	// replaces Go's default behavior of dumping them and exiting.
+	dumpSignal, err := configSignal(runtime, "runtime", "dumpsignal", "none")
+	if err != nil {
+		return
+	}
+
+	// Upgrading is disabled by default, as existing deployments may rely
+	// on signals like SIGUSR2 terminating the process.
+	upgradeSignal, err := configSignal(runtime, "runtime", "upgradesignal", "none")
+	if err != nil {
+		return
+	}
+
+	err = checkSignals(
+		signalOption{"[log] debugsignal", debugSignal},
+		signalOption{"[runtime] dumpsignal", dumpSignal},
+		signalOption{"[runtime] upgradesignal", upgradeSignal})
+	if err != nil {
+		return
+	}
+
+	signals := []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP}
+	for _, s := range []os.Signal{debugSignal, dumpSignal, upgradeSignal} {
+		if s != nil {
+			signals = append(signals, s)
+		}
+	}
+
 	sig := make(chan os.Signal, 3)
-	signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	signal.Notify(sig, signals...)
 	defer signal.Stop(sig)
 
 	go func() {
@@ -111,15 +225,28 @@ func (runtime *runtime) Run() (err error) {
 		for s := range sig {
 			switch s {
 			case os.Interrupt, syscall.SIGTERM:
-				runtime.Printf("Got signal %d, stopping all services", s)
-				runtime.Stop()
+				runtime.Infof("Got signal %d, stopping all services", s)
+				runtime.drain()
 				break Loop
 			case syscall.SIGHUP:
-				runtime.Printf("Got signal %d, reloading all services", s)
+				runtime.Infof("Got signal %d, reloading all services", s)
 				if err := runtime.Reload(); err != nil {
-					runtime.Printf("Error reloading services: %v", err)
+					runtime.Errorf("Error reloading services: %v", err)
 					runtime.Stop()
 				}
+			case debugSignal:
+				level := runtime.logwriter.thresholds.toggleDebug(debugTimeout)
+				if level == logLevelDebug && debugTimeout > 0 {
+					runtime.Warningf("Got signal %d, logging at level %s for %s", s, level, debugTimeout)
+				} else {
+					runtime.Warningf("Got signal %d, logging at level %s", s, level)
+				}
+			case dumpSignal:
+				runtime.Warningf("Got signal %d, dumping goroutines", s)
+				runtime.dumpState()
+			case upgradeSignal:
+				runtime.Infof("Got signal %d, upgrading", s)
+				go runtime.upgrade()
 			}
 		}
 	}()
@@ -141,6 +268,9 @@ func (runtime *runtime) SetTLSConfig(tlsConfig *tls.Config) {
 }
 
 func (runtime *runtime) Start() error {
+	// The administrative endpoints are only served if [admin] listen is set.
+	runtime.appendHTTPServices("admin", newAdminHandler(runtime), false)
+
 	stopCallbacks := make([]callback, 0)
 	defer func() {
 		for _, cb := range stopCallbacks {
@@ -156,18 +286,47 @@ func (runtime *runtime) Start() error {
 		stopCallbacks = append([]callback{cb}, stopCallbacks...)
 	}
 
+	go runtime.notifyReady()
 	return runtime.serviceManager.Start()
 }
 
+func (runtime *runtime) Reload() (err error) {
+	if err = runtime.notifier.notify("RELOADING=1", "STATUS=Reloading"); err != nil {
+		runtime.Warningf("Failed to notify service manager: %v", err)
+	}
+	err = runtime.serviceManager.Reload()
+	if notifyErr := runtime.notifier.notify("READY=1", "STATUS=Running"); notifyErr != nil {
+		runtime.Warningf("Failed to notify service manager: %v", notifyErr)
+	}
+	return
+}
+
 func (runtime *runtime) Stop() (err error) {
+	if notifyErr := runtime.notifier.notify("STOPPING=1", "STATUS=Stopping"); notifyErr != nil {
+		runtime.Warningf("Failed to notify service manager: %v", notifyErr)
+	}
 	if err = runtime.serviceManager.Stop(); err != nil {
-		runtime.Printf("Error stopping server: %v", err)
+		runtime.Errorf("Error stopping server: %v", err)
 	}
 	return
 }
 
 func (runtime *runtime) Service(service Service) {
-	runtime.AddService(service)
+	if err := runtime.AddService(service); err != nil {
+		runtime.Errorf("Failed to add service: %v", err)
+	}
+}
+
+func (runtime *runtime) ServiceAfter(service Service, after ...Service) {
+	if err := runtime.AddService(service, after...); err != nil {
+		runtime.Errorf("Failed to add service: %v", err)
+	}
+}
+
+func (runtime *runtime) ContextService(service ContextService) {
+	if err := runtime.AddContextService(service); err != nil {
+		runtime.Errorf("Failed to add service: %v", err)
+	}
 }
 
 func (runtime *runtime) DefaultHTTPHandler(handler http.Handler) {
@@ -199,17 +358,26 @@ func (runtime *runtime) appendHTTPServices(section string, handler http.Handler,
 		writetimeout = 10
 	}
 
+	shutdowntimeout, err := runtime.GetInt(section, "shutdowntimeout")
+	if err != nil {
+		shutdowntimeout = 5
+	}
+
 	var tlsConfig *tls.Config
 	if section == "https" {
 		tlsConfig, err = runtime.TLSConfig()
 		if err != nil {
-			runtime.OnStart(func(r Runtime) error {
-				return err
-			})
+			runtime.failHTTPServices(section, err)
 			return
 		}
 	}
 
+	var accessLog *accessLog
+	if accessLog, err = runtime.openAccessLog(section); err != nil {
+		runtime.failHTTPServices(section, err)
+		return
+	}
+
 	// Loop through each listen address, seperated by space
 	addresses := strings.Split(listen, " ")
 	for _, addr := range addresses {
@@ -218,6 +386,76 @@ func (runtime *runtime) appendHTTPServices(section string, handler http.Handler,
 			continue
 		}
 
-		runtime.Service(newHTTPService(runtime.rawLogger, handler, addr, readtimeout, writetimeout, tlsConfig))
+		listeners, err := runtime.inheritedListeners(addr)
+		if err != nil {
+			runtime.failHTTPServices(section, err)
+			return
+		}
+		if len(listeners) == 0 {
+			// Listen on our own once started.
+			listeners = []net.Listener{nil}
+		}
+		for _, listener := range listeners {
+			runtime.Service(newHTTPService(runtime.rawLogger, handler, addr, listener, readtimeout, writetimeout, shutdowntimeout, tlsConfig, accessLog))
+		}
+	}
+}
+
+// failHTTPServices reports an error setting up the servers of section. It
+// fails Start, unless the servers were added after it was called already.
+func (runtime *runtime) failHTTPServices(section string, err error) {
+	if runtime.launched() {
+		runtime.Errorf("Failed to add %s servers: %v", section, err)
+		return
+	}
+	runtime.OnStart(func(r Runtime) error {
+		return err
+	})
+}
+
+// inheritedListeners claims the sockets passed for the listen entry addr,
+// either by an upgrading process or, for entries like "systemd:name", by
+// systemd.
+func (runtime *runtime) inheritedListeners(addr string) ([]net.Listener, error) {
+	if runtime.inherited == nil {
+		inherited, err := upgradeListeners()
+		if err == nil && inherited == nil {
+			inherited, err = activatedListeners()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if inherited == nil {
+			inherited = make(map[string][]net.Listener)
+		}
+		runtime.inherited = inherited
+	}
+
+	listeners := runtime.inherited[addr]
+	if len(listeners) == 0 && strings.HasPrefix(addr, "systemd:") {
+		return nil, fmt.Errorf("no socket named '%s' was passed by systemd", strings.TrimPrefix(addr, "systemd:"))
+	}
+	delete(runtime.inherited, addr)
+	return listeners, nil
+}
+
+// openAccessLog opens the access log given by the accesslog option of
+// section, if any. The accessformat option selects between the "common"
+// (default), "combined" and "json" formats.
+func (runtime *runtime) openAccessLog(section string) (*accessLog, error) {
+	destination, err := runtime.GetString(section, "accesslog")
+	if err != nil || destination == "" {
+		return nil, nil
+	}
+
+	format, err := parseAccessLogFormat(runtime.GetStringDefault(section, "accessformat", "common"))
+	if err != nil {
+		return nil, err
+	}
+
+	dispatcher, err := runtime.container.openAccessLog(destination)
+	if err != nil {
+		return nil, err
 	}
+	return &accessLog{dispatcher, format}, nil
 }
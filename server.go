@@ -11,7 +11,6 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"path"
-	goruntime "runtime"
 	"runtime/pprof"
 )
 
@@ -38,9 +37,16 @@ type Server interface {
 	// OverrideConfig sets the path to the application's override config file.
 	OverrideConfig(path *string) Server
 
-	// Log sets the path to the application's logfile. Defaults to stderr if unset.
+	// Log sets the path to the application's logfile, replacing any outputs
+	// configured in the [log] section. Defaults to stderr if unset.
 	Log(path *string) Server
 
+	// GlobalLogger controls whether the core logging package, and log/slog
+	// if [log] slogdefault is set, are redirected to the application's
+	// log while it runs. Defaults to true, disable it to run multiple
+	// servers with separate logs in one process.
+	GlobalLogger(enabled bool) Server
+
 	// CpuProfile runs the application with CPU profiling enabled,
 	// writing the results to path.
 	CpuProfile(path *string) Server
@@ -63,6 +69,7 @@ type Server interface {
 type server struct {
 	Name, Version          string
 	logPath                *string
+	globalLogger           bool
 	cpuProfile, memProfile *string
 	currentRuntime         *runtime
 	*config
@@ -71,9 +78,10 @@ type server struct {
 // NewServer creates a Server instance with the given name and version string.
 func NewServer(name, version string) Server {
 	return &server{
-		Name:    name,
-		Version: version,
-		config:  newConfig(),
+		Name:         name,
+		Version:      version,
+		globalLogger: true,
+		config:       newConfig(),
 	}
 }
 
@@ -107,6 +115,11 @@ func (server *server) Log(path *string) Server {
 	return server
 }
 
+func (server *server) GlobalLogger(enabled bool) Server {
+	server.globalLogger = enabled
+	return server
+}
+
 func (server *server) CpuProfile(path *string) Server {
 	server.cpuProfile = path
 	return server
@@ -122,13 +135,15 @@ func (server *server) Run(runFunc RunFunc) (err error) {
 		return fmt.Errorf("server is already running")
 	}
 
-	container, err := newContainer(server.Name, server.Version, server.logPath, server.config)
+	container, err := newContainer(server.Name, server.Version, server.logPath, server.config, server.globalLogger)
 	if err != nil {
 		makeLogger(server.Name, os.Stderr).Print(err)
 		return err
 	}
 	defer container.Close()
 
+	runtime := newRuntime(container, runFunc)
+
 	// Now that logging is started, install a panic handler.
 	defer func() {
 		if recovered := recover(); recovered != nil {
@@ -138,26 +153,14 @@ func (server *server) Run(runFunc RunFunc) (err error) {
 				err = fmt.Errorf("%v", recovered)
 			}
 
-			stackTrace := make([]byte, 1024)
-			for {
-				n := goruntime.Stack(stackTrace, false)
-				if n < len(stackTrace) {
-					stackTrace = stackTrace[0:n]
-					break
-				}
-				stackTrace = make([]byte, len(stackTrace)*2)
-			}
-
-			container.Printf("%v\n%s", err, stackTrace)
+			runtime.reportCrash(err)
 		}
 	}()
 
-	runtime := newRuntime(container, runFunc)
-
 	if server.cpuProfile != nil && *server.cpuProfile != "" {
 		runtime.OnStart(func(runtime Runtime) error {
 			cpuprofilepath := path.Clean(*server.cpuProfile)
-			runtime.Printf("Writing CPU profile to %s", cpuprofilepath)
+			runtime.Infof("Writing CPU profile to %s", cpuprofilepath)
 
 			f, err := os.Create(cpuprofilepath)
 			if err != nil {
@@ -175,16 +178,16 @@ func (server *server) Run(runFunc RunFunc) (err error) {
 		memprofilepath := path.Clean(*server.memProfile)
 		var profileData io.WriteCloser
 		runtime.OnStart(func(runtime Runtime) (err error) {
-			runtime.Printf("A memory profile will be written to %s on exit.", memprofilepath)
+			runtime.Infof("A memory profile will be written to %s on exit.", memprofilepath)
 			profileData, err = os.Create(memprofilepath)
 			return
 		})
 
 		runtime.OnStop(func(runtime Runtime) {
-			runtime.Printf("Writing memory profile to %s", memprofilepath)
+			runtime.Infof("Writing memory profile to %s", memprofilepath)
 			defer profileData.Close()
 			if err := pprof.Lookup("heap").WriteTo(profileData, 0); err != nil {
-				runtime.Printf("Failed to create memory profile: %v", err)
+				runtime.Errorf("Failed to create memory profile: %v", err)
 			}
 		})
 	}
@@ -5,7 +5,9 @@
 package phoenix
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"strings"
 	"sync"
 	"time"
@@ -28,6 +30,19 @@ type Service interface {
 	Stop() error
 }
 
+// ContextService is an alternative to Service for services which honor
+// cancellation and deadlines. Optional interfaces such as Reloadable apply
+// to it in the same way.
+type ContextService interface {
+	// Start runs the main loop of the service, like Service.Start. The
+	// context is cancelled once the runtime begins shutting down.
+	Start(ctx context.Context) error
+
+	// Stop shall terminate execution of Start, like Service.Stop. The
+	// context carries the deadline by which the service must have stopped.
+	Stop(ctx context.Context) error
+}
+
 // Reloadable should be implemented by services which wish to respond to
 // configuration reload requests.
 type Reloadable interface {
@@ -51,85 +66,659 @@ type StopHandler interface {
 	OnStop(Container)
 }
 
+// ReadyNotifier may be implemented by services which take a while to become
+// ready after being started, such as those binding sockets or warming up
+// caches. Other services are considered ready once they are running.
+type ReadyNotifier interface {
+	// SetReadyFunc is called before the service is started with a function
+	// the service must call once it is ready. Calling it more than once has
+	// no further effect.
+	SetReadyFunc(ready func())
+}
+
+// ServiceState is the state of a service managed by a Runtime.
+type ServiceState int
+
+const (
+	ServiceStopped ServiceState = iota
+	ServiceStarting
+	ServiceRunning
+	ServiceStopping
+	ServiceFailed
+)
+
+var serviceStateNames = []string{"stopped", "starting", "running", "stopping", "failed"}
+
+func (state ServiceState) String() string {
+	if state < ServiceStopped || int(state) >= len(serviceStateNames) {
+		return fmt.Sprintf("ServiceState(%d)", int(state))
+	}
+	return serviceStateNames[state]
+}
+
+// serviceStopTimeout is the time given to each service to stop, unless it
+// implements StopTimeouter.
+const serviceStopTimeout = 5 * time.Second
+
+// StopTimeouter may be implemented by services which need more or less
+// time to stop than the default of five seconds.
+type StopTimeouter interface {
+	// StopTimeout returns the time the service is given to stop. If it
+	// takes longer, it is abandoned and an error is reported.
+	StopTimeout() time.Duration
+}
+
+// managedService tracks the state of a Service or ContextService on behalf
+// of the manager.
+type managedService struct {
+	impl  interface{}
+	name  string
+	state ServiceState
+	// started is when the service last began running, lastErr the last
+	// error it failed with.
+	started  time.Time
+	lastErr  error
+	restarts int
+	// abandoned is set if the service did not stop in time.
+	abandoned bool
+	// removing is set while RemoveService stops the service, which stays
+	// registered until it has stopped.
+	removing bool
+	// healthErr is the result of the last health check.
+	healthErr error
+	// after lists services registered as dependencies of this one.
+	after      []interface{}
+	deps       []*managedService
+	dependents []*managedService
+	// ready is closed once the service is ready, done once its start
+	// goroutine finished and stopped once Stop has completed.
+	ready, done, stopped chan struct{}
+	readyOnce            sync.Once
+	// ctx is passed to ContextService.Start and cancelled when the service
+	// is removed or the manager stopped.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newManagedService(impl interface{}, after []interface{}) *managedService {
+	return &managedService{
+		impl:    impl,
+		after:   after,
+		ready:   make(chan struct{}),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+func (service *managedService) markReady() {
+	service.readyOnce.Do(func() {
+		close(service.ready)
+	})
+}
+
+func (service *managedService) start(ctx context.Context) error {
+	if contextService, ok := service.impl.(ContextService); ok {
+		return contextService.Start(ctx)
+	}
+	return service.impl.(Service).Start()
+}
+
+func (service *managedService) stop(ctx context.Context) error {
+	if contextService, ok := service.impl.(ContextService); ok {
+		return contextService.Stop(ctx)
+	}
+	return service.impl.(Service).Stop()
+}
+
+func (service *managedService) String() string {
+	if service.name != "" {
+		return service.name
+	}
+	return service.describe()
+}
+
+// baseName returns the name of service given by ServiceName, or else its
+// description, before any suffix making it unique was appended.
+func (service *managedService) baseName() string {
+	if named, ok := service.impl.(NamedService); ok {
+		return named.ServiceName()
+	}
+	return service.describe()
+}
+
+func (service *managedService) describe() string {
+	if stringer, ok := service.impl.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	return fmt.Sprintf("%T", service.impl)
+}
+
+// servicePanic is the error reported for a service which panicked.
+type servicePanic struct {
+	service string
+	value   interface{}
+	stack   []byte
+}
+
+func (err *servicePanic) Error() string {
+	return fmt.Sprintf("service %s panicked: %v\n%s", err.service, err.value, err.stack)
+}
+
 type serviceManager struct {
 	*container
-	services []Service
+	sync.Mutex
+	services []*managedService
+	// onPanic reports a panic recovered from a service, returning the
+	// captured stack.
+	onPanic func(error) []byte
+	// ctx is passed to ContextService.Start and cancelled by Stop.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// ready is closed once all services are ready.
+	ready chan struct{}
+	// stopOnce guards stopping services, stopErr holds the result and
+	// stopped is closed once they were stopped.
+	stopOnce sync.Once
+	stopErr  error
+	stopped  chan struct{}
+	// shutdown stops all services on behalf of the manager, e.g. when a
+	// service is crash looping.
+	shutdown func()
+	// healthChecked is the time of the last health check.
+	healthChecked time.Time
+	// subscribers receive service state transitions.
+	subscribers map[chan ServiceEvent]struct{}
+	// started is set once Start has launched the services. active counts
+	// their goroutines, and idle is closed once none are left.
+	started bool
+	active  int
+	idle    chan struct{}
+	// fail receives the errors of failed services until returned is
+	// closed by Start.
+	fail     chan error
+	returned chan struct{}
 }
 
 func newServiceManager(container *container) *serviceManager {
-	return &serviceManager{
-		container,
-		make([]Service, 0, 1),
+	ctx, cancel := context.WithCancel(context.Background())
+	manager := &serviceManager{
+		container: container,
+		services:  make([]*managedService, 0, 1),
+		ctx:       ctx,
+		cancel:    cancel,
+		ready:     make(chan struct{}),
+		stopped:   make(chan struct{}),
+		idle:      make(chan struct{}),
+		returned:  make(chan struct{}),
+	}
+	manager.shutdown = func() {
+		manager.Stop()
+	}
+	return manager
+}
+
+// AddService registers service, which will be started once the services
+// it depends on are ready. Services added while running are started right
+// away.
+func (manager *serviceManager) AddService(service Service, after ...Service) error {
+	deps := make([]interface{}, len(after))
+	for i, dep := range after {
+		deps[i] = dep
 	}
+	return manager.add(newManagedService(service, deps))
+}
+
+func (manager *serviceManager) AddContextService(service ContextService) error {
+	return manager.add(newManagedService(service, nil))
 }
 
-func (manager *serviceManager) AddService(service Service) {
+func (manager *serviceManager) add(service *managedService) error {
+	manager.Lock()
+	defer manager.Unlock()
+
+	if !manager.started {
+		manager.services = append(manager.services, service)
+		return nil
+	}
+	if manager.ctx.Err() != nil || manager.active == 0 {
+		return fmt.Errorf("cannot add service %s, services are stopping", service)
+	}
+
+	byName := manager.servicesByName()
+	manager.nameService(service, byName)
+	if err := manager.linkService(service, byName); err != nil {
+		unlinkService(service)
+		return err
+	}
 	manager.services = append(manager.services, service)
+	manager.Infof("Adding service %s", service)
+	manager.launch(service)
+	return nil
+}
+
+// RemoveService stops the service of the given name and removes it. It
+// fails if other services depend on it.
+func (manager *serviceManager) RemoveService(name string) error {
+	manager.Lock()
+	index := -1
+	for i, service := range manager.services {
+		if service.name == name || (service.name == "" && service.baseName() == name) {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		manager.Unlock()
+		return fmt.Errorf("unknown service %s", name)
+	}
+	service := manager.services[index]
+	if service.removing {
+		manager.Unlock()
+		return fmt.Errorf("service %s is being removed already", service)
+	}
+	if len(service.dependents) > 0 {
+		manager.Unlock()
+		return fmt.Errorf("cannot remove service %s, it is required by %s", service, service.dependents[0])
+	}
+	if !manager.started {
+		manager.services = append(manager.services[:index:index], manager.services[index+1:]...)
+		unlinkService(service)
+		manager.Unlock()
+		return nil
+	}
+	if manager.ctx.Err() != nil {
+		manager.Unlock()
+		return fmt.Errorf("cannot remove service %s, services are stopping", service)
+	}
+	// Stop waits for the service to be stopped here, rather than
+	// stopping it again.
+	service.removing = true
+	manager.Unlock()
+
+	manager.Infof("Removing service %s", service)
+	service.cancel()
+	err := manager.stopService(context.Background(), service)
+	close(service.stopped)
+
+	manager.Lock()
+	abandoned := service.abandoned
+	manager.Unlock()
+	if !abandoned {
+		<-service.done
+	}
+
+	manager.Lock()
+	for i, registered := range manager.services {
+		if registered == service {
+			manager.services = append(manager.services[:i:i], manager.services[i+1:]...)
+			break
+		}
+	}
+	unlinkService(service)
+	manager.Unlock()
+	return err
+}
+
+// launched reports whether Start has launched the services.
+func (manager *serviceManager) launched() bool {
+	manager.Lock()
+	defer manager.Unlock()
+	return manager.started
+}
+
+// snapshot returns the currently registered services.
+func (manager *serviceManager) snapshot() []*managedService {
+	manager.Lock()
+	defer manager.Unlock()
+	return append([]*managedService(nil), manager.services...)
+}
+
+// protect calls fn on behalf of service, converting any panic into a
+// servicePanic error after reporting it.
+func (manager *serviceManager) protect(service *managedService, fn func() error) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			fault := &servicePanic{service: service.String(), value: recovered}
+			if manager.onPanic != nil {
+				fault.stack = manager.onPanic(fmt.Errorf("service %s panicked: %v", fault.service, recovered))
+			} else {
+				fault.stack = captureStack(false)
+			}
+			err = fault
+		}
+	}()
+	return fn()
+}
+
+// setState changes the state of service, recording err if it failed.
+func (manager *serviceManager) setState(service *managedService, state ServiceState, err error) {
+	manager.Lock()
+	defer manager.Unlock()
+	manager.transition(service, state, err)
+}
+
+// transition is like setState, but must be called with the lock held. All
+// subscribers are notified of changes.
+func (manager *serviceManager) transition(service *managedService, state ServiceState, err error) {
+	if err != nil {
+		service.lastErr = err
+	}
+	if state == ServiceRunning {
+		service.started = time.Now()
+	}
+	if state == service.state {
+		return
+	}
+
+	event := ServiceEvent{
+		Service: service.String(),
+		From:    service.state,
+		To:      state,
+		Time:    time.Now(),
+		Err:     err,
+	}
+	service.state = state
+	manager.publish(event)
+}
+
+// healthy reports whether no service has failed or reported to be
+// unhealthy.
+func (manager *serviceManager) healthy() bool {
+	manager.Lock()
+	defer manager.Unlock()
+
+	for _, service := range manager.services {
+		if healthOf(service) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// serviceStates describes each service and its current state.
+func (manager *serviceManager) serviceStates() []string {
+	manager.Lock()
+	defer manager.Unlock()
+
+	states := make([]string, 0, len(manager.services))
+	for _, service := range manager.services {
+		state := fmt.Sprintf("%s: %s", service, service.state)
+		if service.restarts > 0 {
+			state += fmt.Sprintf(" (%d restarts)", service.restarts)
+		}
+		states = append(states, state)
+	}
+	return states
 }
 
 func (manager *serviceManager) Start() error {
-	if len(manager.services) <= 0 {
+	if len(manager.snapshot()) <= 0 {
 		return errors.New("no services were registered")
 	}
 
-	running := &sync.WaitGroup{}
-	fail := make(chan error, len(manager.services))
+	failFast, err := manager.failFast()
+	if err != nil {
+		return err
+	}
+	if err := manager.resolveDependencies(); err != nil {
+		return err
+	}
+	defer close(manager.returned)
 
+	manager.Lock()
+	manager.fail = make(chan error, len(manager.services))
+	manager.started = true
 	for _, service := range manager.services {
-		running.Add(1)
-		go func(srv Service) {
-			defer running.Done()
-
-			if handler, ok := srv.(StartHandler); ok {
-				if err := handler.OnStart(manager); err != nil {
-					fail <- err
-					return
+		manager.launch(service)
+	}
+	manager.Unlock()
+
+	go manager.awaitReady()
+	go manager.pollHealth()
+
+	faults := &multiError{}
+	failed := false
+	stopped, settled := manager.stopped, make(chan struct{})
+Loop:
+	for {
+		select {
+		case <-manager.idle:
+			break Loop
+		case <-stopped:
+			stopped = nil
+			go func() {
+				manager.awaitStopped()
+				close(settled)
+			}()
+		case <-settled:
+			break Loop
+		case err := <-manager.fail:
+			faults.AddError(err)
+			if failFast {
+				if !failed {
+					manager.Errorf("Service failed, stopping all services: %v", err)
+					go manager.shutdown()
 				}
+				failed = true
+			} else {
+				manager.Errorf("Service failed, keeping other services running: %v", err)
 			}
+		}
+	}
 
-			if err := srv.Start(); err != nil {
-				manager.Printf("Error while listening %s\n", err)
-				fail <- err
-			} else if handler, ok := srv.(StopHandler); ok {
-				handler.OnStop(manager)
-			}
-		}(service)
+	// Services which failed while others kept running don't fail a
+	// shutdown, but do once all services terminated on their own.
+	if !failFast && manager.ctx.Err() != nil {
+		return nil
 	}
 
-	done := make(chan bool)
+	// Collect the errors of services which failed while stopping.
+	for {
+		select {
+		case err := <-manager.fail:
+			faults.AddError(err)
+		default:
+			return faults.AsError()
+		}
+	}
+}
+
+// launch runs service in a goroutine of its own. It must be called with
+// the lock held.
+func (manager *serviceManager) launch(service *managedService) {
+	service.ctx, service.cancel = context.WithCancel(manager.ctx)
+	if notifier, ok := service.impl.(ReadyNotifier); ok {
+		notifier.SetReadyFunc(service.markReady)
+	}
+
+	manager.active++
 	go func() {
-		running.Wait()
-		close(done)
+		if err := manager.supervise(service); err != nil {
+			select {
+			case manager.fail <- err:
+			case <-manager.returned:
+				manager.Errorf("Service failed: %v", err)
+			}
+		}
+		close(service.done)
+
+		manager.Lock()
+		defer manager.Unlock()
+		if manager.active--; manager.active == 0 {
+			close(manager.idle)
+		}
 	}()
+}
 
-	faults := &multiError{}
-	select {
-	case <-done:
-	case err := <-fail:
-		faults.AddError(err)
-		// NOTE(lcooper): We'll bail eventually, collect all errors first.
-	Loop:
-		for {
-			select {
-			case err := <-fail:
-				faults.AddError(err)
-			case <-time.After(500 * time.Millisecond):
-				break Loop
+// awaitStopped waits for the start goroutines of all services which were
+// not abandoned by Stop to return.
+func (manager *serviceManager) awaitStopped() {
+	for _, service := range manager.snapshot() {
+		manager.Lock()
+		abandoned := service.abandoned
+		manager.Unlock()
+		if !abandoned {
+			<-service.done
+		}
+	}
+}
+
+// failFast returns whether all services are to be stopped once one has
+// failed, as by default, or left running, depending on [runtime]
+// failurepolicy.
+func (manager *serviceManager) failFast() (bool, error) {
+	switch policy := manager.GetStringDefault("runtime", "failurepolicy", "failfast"); strings.ToLower(policy) {
+	case "failfast":
+		return true, nil
+	case "keeprunning":
+		return false, nil
+	default:
+		return false, fmt.Errorf("unknown failure policy '%s'", policy)
+	}
+}
+
+// supervise runs service once its dependencies are ready, restarting it
+// according to its restart policy. Crash looping services are given up on
+// and a shutdown of the runtime is requested.
+func (manager *serviceManager) supervise(service *managedService) error {
+	if err := manager.awaitDependencies(service); err != nil {
+		manager.setState(service, ServiceFailed, err)
+		return err
+	}
+
+	policy, err := manager.restartPolicy(service)
+	if err != nil {
+		manager.setState(service, ServiceFailed, err)
+		return err
+	}
+	backoff := newRestartBackoff(manager)
+
+	for {
+		err := manager.run(service)
+		if service.ctx.Err() != nil || !policy.restarts(err) {
+			return err
+		}
+
+		delay, ok := backoff.next(time.Now())
+		if !ok {
+			err = fmt.Errorf("service %s is crash looping, it was restarted %d times within %s: %v",
+				service, backoff.limit, backoff.window, err)
+			manager.setState(service, ServiceFailed, err)
+			manager.Errorf("%v, shutting down", err)
+			go manager.shutdown()
+			return err
+		}
+
+		manager.Lock()
+		service.restarts++
+		restarts := service.restarts
+		manager.Unlock()
+		if err != nil {
+			manager.Warningf("Restarting service %s in %s (restart %d): %v", service, delay, restarts, err)
+		} else {
+			manager.Warningf("Restarting service %s in %s (restart %d) after it returned", service, delay, restarts)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-service.ctx.Done():
+			return err
+		}
+	}
+}
+
+// run starts service once, returning when its Start method returned.
+func (manager *serviceManager) run(service *managedService) error {
+	manager.setState(service, ServiceStarting, nil)
+	if handler, ok := service.impl.(StartHandler); ok {
+		err := manager.protect(service, func() error {
+			return handler.OnStart(manager)
+		})
+		if err != nil {
+			manager.setState(service, ServiceFailed, err)
+			return err
+		}
+	}
+
+	manager.setState(service, ServiceRunning, nil)
+	if _, ok := service.impl.(ReadyNotifier); !ok {
+		service.markReady()
+	}
+	err := manager.protect(service, func() error {
+		return service.start(service.ctx)
+	})
+	if err != nil {
+		manager.setState(service, ServiceFailed, err)
+		manager.Errorf("Error while listening %s", err)
+		return err
+	}
+
+	manager.setState(service, ServiceStopped, nil)
+	if handler, ok := service.impl.(StopHandler); ok {
+		manager.protect(service, func() error {
+			handler.OnStop(manager)
+			return nil
+		})
+	}
+	return nil
+}
+
+// Ready returns a channel which is closed once all services are ready.
+func (manager *serviceManager) Ready() <-chan struct{} {
+	return manager.ready
+}
+
+// awaitReady closes the manager's ready channel once all services are
+// ready or were removed, unless the manager is stopped first.
+func (manager *serviceManager) awaitReady() {
+	for _, service := range manager.snapshot() {
+		select {
+		case <-service.ready:
+		case <-service.ctx.Done():
+			if manager.ctx.Err() != nil {
+				return
 			}
 		}
 	}
+	manager.Infof("All services are ready")
+	close(manager.ready)
+}
 
-	return faults.AsError()
+// awaitDependencies blocks until all dependencies of service are ready,
+// returning an error if any of them terminated before becoming ready.
+func (manager *serviceManager) awaitDependencies(service *managedService) error {
+	for _, dep := range service.deps {
+		select {
+		case <-dep.ready:
+		case <-dep.done:
+			select {
+			case <-dep.ready:
+			default:
+				return fmt.Errorf("service %s was not started, its dependency %s failed", service, dep)
+			}
+		case <-service.ctx.Done():
+			return fmt.Errorf("service %s was not started, shutting down", service)
+		}
+	}
+	return nil
 }
 
 func (manager *serviceManager) Reload() error {
+	// Pick up log files which have been rotated in the meantime.
+	if err := manager.reopenLogs(); err != nil {
+		manager.Errorf("Error reopening logs: %v", err)
+	}
+
 	if err := manager.config.load(); err != nil {
 		return err
 	}
 
 	failedToReload := &multiError{}
-	for _, service := range manager.services {
-		if reloadable, ok := service.(Reloadable); ok {
+	for _, service := range manager.snapshot() {
+		manager.Lock()
+		removing := service.removing
+		manager.Unlock()
+		if reloadable, ok := service.impl.(Reloadable); ok && !removing {
 			failedToReload.AddError(reloadable.Reload())
 		}
 	}
@@ -137,26 +726,50 @@ func (manager *serviceManager) Reload() error {
 	return failedToReload.AsError()
 }
 
+// Stop stops all services, giving them at most [runtime] shutdowntimeout
+// seconds in total if set. Further calls wait for the first to complete.
 func (manager *serviceManager) Stop() error {
+	manager.stopOnce.Do(func() {
+		manager.stopErr = manager.stop()
+		close(manager.stopped)
+	})
+	return manager.stopErr
+}
+
+func (manager *serviceManager) stop() error {
+	// Signal the start context of all services that we're shutting down.
+	manager.cancel()
+
+	budget, cancel := context.WithCancel(context.Background())
+	if seconds := manager.GetIntDefault("runtime", "shutdowntimeout", 0); seconds > 0 {
+		budget, cancel = context.WithTimeout(context.Background(), time.Duration(seconds)*time.Second)
+	}
+	defer cancel()
+
+	// Services are stopped concurrently, but each only after all services
+	// depending on it have stopped.
+	services := manager.snapshot()
 	faults := &multiError{}
 	stopping := sync.WaitGroup{}
-	for i := len(manager.services) - 1; i >= 0; i-- {
-		service := manager.services[i]
-		fault := make(chan error, 1)
+	for i := len(services) - 1; i >= 0; i-- {
+		service := services[i]
 		stopping.Add(1)
-		go func() {
-			fault <- service.Stop()
-		}()
-
+		manager.Lock()
+		removing := service.removing
+		dependents := append([]*managedService(nil), service.dependents...)
+		manager.Unlock()
 		go func() {
 			defer stopping.Done()
-			var err error
-			select {
-			case err = <-fault:
-			case <-time.After(5 * time.Second):
-				err = errors.New("timed out waiting for service to stop")
+			if removing {
+				// RemoveService is stopping it already.
+				<-service.stopped
+				return
 			}
-			faults.AddError(err)
+			defer close(service.stopped)
+			for _, dependent := range dependents {
+				<-dependent.stopped
+			}
+			faults.AddError(manager.stopService(budget, service))
 		}()
 	}
 
@@ -164,6 +777,43 @@ func (manager *serviceManager) Stop() error {
 	return faults.AsError()
 }
 
+// stopService stops service, waiting for as long as the service's stop
+// timeout and the remaining shutdown budget allow.
+func (manager *serviceManager) stopService(budget context.Context, service *managedService) error {
+	manager.Lock()
+	if service.state == ServiceStarting || service.state == ServiceRunning {
+		manager.transition(service, ServiceStopping, nil)
+	}
+	manager.Unlock()
+
+	timeout := serviceStopTimeout
+	if timeouter, ok := service.impl.(StopTimeouter); ok {
+		timeout = timeouter.StopTimeout()
+	}
+	ctx, cancel := context.WithTimeout(budget, timeout)
+	defer cancel()
+
+	fault := make(chan error, 1)
+	go func() {
+		fault <- manager.protect(service, func() error {
+			return service.stop(ctx)
+		})
+	}()
+
+	select {
+	case err := <-fault:
+		return err
+	case <-ctx.Done():
+		manager.Lock()
+		service.abandoned = true
+		manager.Unlock()
+		if budget.Err() != nil {
+			return fmt.Errorf("service %s did not stop within the shutdown timeout", service)
+		}
+		return fmt.Errorf("service %s did not stop within %s", service, timeout)
+	}
+}
+
 type multiError struct {
 	sync.Mutex
 	errors []error
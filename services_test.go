@@ -0,0 +1,643 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type panickingService struct{}
+
+func (service *panickingService) Start() error {
+	panic("service exploded")
+}
+
+func (service *panickingService) Stop() error {
+	return nil
+}
+
+func newTestRuntime(t *testing.T) (*runtime, func()) {
+	logFile, err := ioutil.TempFile("", "phoenix-services")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	logFile.Close()
+
+	logPath := logFile.Name()
+	container, err := newContainer("test", "", &logPath, newConfig(), false)
+	if err != nil {
+		t.Fatalf("Unexpected error creating container: %v", err)
+	}
+	return newRuntime(container, nil), func() {
+		container.Close()
+		os.Remove(logPath)
+	}
+}
+
+func Test_ServiceManager_RecoversPanicsAsServiceErrors(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+
+	runtime.Service(&panickingService{})
+	err := runtime.Start()
+	if err == nil {
+		t.Fatalf("Expected the panic to be reported as an error")
+	}
+	if !strings.Contains(err.Error(), "service exploded") || !strings.Contains(err.Error(), "goroutine ") {
+		t.Errorf("Expected the error to contain the panic and its stack, but was '%v'", err)
+	}
+	if states := runtime.serviceStates(); len(states) != 1 || !strings.HasSuffix(states[0], ": failed") {
+		t.Errorf("Expected the service to have failed, but got %v", states)
+	}
+}
+
+type contextService struct {
+	started     chan struct{}
+	hadDeadline bool
+}
+
+func (service *contextService) Start(ctx context.Context) error {
+	close(service.started)
+	<-ctx.Done()
+	return nil
+}
+
+func (service *contextService) Stop(ctx context.Context) error {
+	_, service.hadDeadline = ctx.Deadline()
+	return nil
+}
+
+func Test_ServiceManager_CancelsContextServicesOnStop(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+
+	service := &contextService{started: make(chan struct{})}
+	runtime.ContextService(service)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runtime.Start()
+	}()
+	<-service.started
+
+	if err := runtime.Stop(); err != nil {
+		t.Errorf("Unexpected error stopping services: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Unexpected error from Start: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected Start to return once its context was cancelled")
+	}
+	if !service.hadDeadline {
+		t.Errorf("Expected the stop context to carry a deadline")
+	}
+}
+
+type orderedService struct {
+	name    string
+	deps    []string
+	started chan<- string
+	stop    chan struct{}
+}
+
+func (service *orderedService) ServiceName() string {
+	return service.name
+}
+
+func (service *orderedService) ServiceDependencies() []string {
+	return service.deps
+}
+
+func (service *orderedService) Start() error {
+	service.started <- service.name
+	<-service.stop
+	return nil
+}
+
+func (service *orderedService) Stop() error {
+	close(service.stop)
+	return nil
+}
+
+func Test_ServiceManager_StartsServicesAfterTheirDependencies(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+
+	started := make(chan string, 3)
+	runtime.Service(&orderedService{"web", []string{"cache", "db"}, started, make(chan struct{})})
+	runtime.Service(&orderedService{"cache", []string{"db"}, started, make(chan struct{})})
+	runtime.Service(&orderedService{"db", nil, started, make(chan struct{})})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runtime.Start()
+	}()
+
+	order := []string{}
+	for len(order) < 3 {
+		select {
+		case name := <-started:
+			order = append(order, name)
+		case <-time.After(time.Second):
+			t.Fatalf("Expected all services to start, but only %v did", order)
+		}
+	}
+	if strings.Join(order, ",") != "db,cache,web" {
+		t.Errorf("Expected services to start in dependency order, but got %v", order)
+	}
+
+	if err := runtime.Stop(); err != nil {
+		t.Errorf("Unexpected error stopping services: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error from Start: %v", err)
+	}
+}
+
+func Test_ServiceManager_RejectsDependencyCycles(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+
+	started := make(chan string, 2)
+	runtime.Service(&orderedService{"a", []string{"b"}, started, make(chan struct{})})
+	runtime.Service(&orderedService{"b", []string{"a"}, started, make(chan struct{})})
+
+	err := runtime.Start()
+	if err == nil || !strings.Contains(err.Error(), "a -> b -> a") {
+		t.Errorf("Expected a dependency cycle error, but got %v", err)
+	}
+}
+
+type funcService func() error
+
+func (service funcService) Start() error {
+	return service()
+}
+
+func (service funcService) Stop() error {
+	return nil
+}
+
+func Test_ServiceManager_RejectsDependenciesOnIncomparableServices(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+
+	first := funcService(func() error { return nil })
+	runtime.Service(first)
+	runtime.ServiceAfter(funcService(func() error { return nil }), first)
+
+	err := runtime.Start()
+	if err == nil || !strings.Contains(err.Error(), "not registered") {
+		t.Errorf("Expected an unregistered dependency error, but got %v", err)
+	}
+}
+
+type readyService struct {
+	contextService
+	ready func()
+}
+
+func (service *readyService) SetReadyFunc(ready func()) {
+	service.ready = ready
+}
+
+func Test_ServiceManager_IsReadyOnceAllServicesAreReady(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+
+	service := &readyService{contextService: contextService{started: make(chan struct{})}}
+	runtime.ContextService(service)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runtime.Start()
+	}()
+	<-service.started
+
+	select {
+	case <-runtime.Ready():
+		t.Fatalf("Expected the runtime not to be ready before the service")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	service.ready()
+	select {
+	case <-runtime.Ready():
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the runtime to be ready once the service was")
+	}
+
+	runtime.Stop()
+	<-done
+}
+
+func Test_ServiceManager_IsReadyOnceUnreadyServicesWereRemoved(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+
+	service := &readyService{contextService: contextService{started: make(chan struct{})}}
+	runtime.ContextService(service)
+	runtime.ContextService(&contextService{started: make(chan struct{})})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runtime.Start()
+	}()
+	<-service.started
+
+	if err := runtime.RemoveService("*phoenix.readyService"); err != nil {
+		t.Fatalf("Unexpected error removing the service: %v", err)
+	}
+	select {
+	case <-runtime.Ready():
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the runtime to be ready once the unready service was removed")
+	}
+
+	runtime.Stop()
+	<-done
+}
+
+type hangingService struct {
+	name    string
+	timeout time.Duration
+	release chan struct{}
+}
+
+func (service *hangingService) Start() error {
+	<-service.release
+	return nil
+}
+
+func (service *hangingService) Stop() error {
+	<-service.release
+	return nil
+}
+
+func (service *hangingService) ServiceName() string {
+	return service.name
+}
+
+func (service *hangingService) StopTimeout() time.Duration {
+	return service.timeout
+}
+
+func Test_ServiceManager_Stop_NamesServicesExceedingTheirStopTimeout(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+
+	service := &hangingService{"flusher", 50 * time.Millisecond, make(chan struct{})}
+	defer close(service.release)
+	runtime.Service(service)
+	go runtime.Start()
+	<-runtime.Ready()
+
+	start := time.Now()
+	err := runtime.Stop()
+	if err == nil || !strings.Contains(err.Error(), "service flusher did not stop within 50ms") {
+		t.Errorf("Expected a timeout error naming the service, but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the service's stop timeout to apply, but stopping took %s", elapsed)
+	}
+}
+
+func Test_ServiceManager_Stop_EnforcesShutdownTimeout(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+	runtime.Update(map[string]map[string]string{"runtime": {"shutdowntimeout": "1"}})
+
+	service := &hangingService{"flusher", time.Minute, make(chan struct{})}
+	defer close(service.release)
+	runtime.Service(service)
+	go runtime.Start()
+	<-runtime.Ready()
+
+	start := time.Now()
+	err := runtime.Stop()
+	if err == nil || !strings.Contains(err.Error(), "service flusher did not stop within the shutdown timeout") {
+		t.Errorf("Expected a shutdown timeout error naming the service, but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the shutdown timeout to apply, but stopping took %s", elapsed)
+	}
+}
+
+type flakyService struct {
+	failures int
+	started  int
+	stop     chan struct{}
+}
+
+func (service *flakyService) Start() error {
+	service.started++
+	if service.started <= service.failures {
+		return errors.New("flaked")
+	}
+	<-service.stop
+	return nil
+}
+
+func (service *flakyService) Stop() error {
+	close(service.stop)
+	return nil
+}
+
+func (service *flakyService) RestartPolicy() RestartPolicy {
+	return RestartOnFailure
+}
+
+func Test_ServiceManager_RestartsFailedServices(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+	runtime.Update(map[string]map[string]string{"runtime": {"restartdelay": "0.01"}})
+
+	service := &flakyService{failures: 2, stop: make(chan struct{})}
+	runtime.Service(service)
+	done := make(chan error, 1)
+	go func() {
+		done <- runtime.Start()
+	}()
+
+	select {
+	case <-runtime.Ready():
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the service to become ready")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if states := runtime.serviceStates(); len(states) != 1 || !strings.HasSuffix(states[0], ": running (2 restarts)") {
+		t.Errorf("Expected the service to run after 2 restarts, but got %v", states)
+	}
+
+	runtime.Stop()
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error from Start: %v", err)
+	}
+}
+
+func Test_ServiceManager_ShutsDownOnCrashLoops(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+	runtime.Update(map[string]map[string]string{"runtime": {"restartdelay": "0.01", "restartlimit": "2"}})
+
+	runtime.Service(&flakyService{failures: 100, stop: make(chan struct{})})
+	err := runtime.Start()
+	if err == nil || !strings.Contains(err.Error(), "crash looping, it was restarted 2 times") {
+		t.Errorf("Expected a crash loop error, but got %v", err)
+	}
+	select {
+	case <-runtime.ctx.Done():
+	case <-time.After(time.Second):
+		t.Errorf("Expected the runtime to shut down")
+	}
+}
+
+func Test_RestartBackoff_DoublesDelaysWithinWindow(t *testing.T) {
+	backoff := &restartBackoff{delay: time.Second, maxDelay: 3 * time.Second, window: time.Minute, limit: 4}
+	now := time.Now()
+	for i, expected := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		if delay, ok := backoff.next(now); !ok || delay != expected {
+			t.Errorf("Expected restart %d to be delayed by %s, but got %s (%v)", i+1, expected, delay, ok)
+		}
+	}
+	if delay, ok := backoff.next(now.Add(2 * time.Minute)); !ok || delay != time.Second {
+		t.Errorf("Expected the delay to reset after the window, but got %s (%v)", delay, ok)
+	}
+}
+
+func Test_RestartPolicy_String_FormatsUnknownPolicies(t *testing.T) {
+	if name := RestartPolicy(7).String(); name != "RestartPolicy(7)" {
+		t.Errorf("Expected an unknown policy to be formatted as a number, but got %s", name)
+	}
+}
+
+type failingService struct{}
+
+func (service *failingService) Start() error {
+	return errors.New("failed to start")
+}
+
+func (service *failingService) Stop() error {
+	return nil
+}
+
+func Test_ServiceManager_FailFast_StopsRemainingServices(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+
+	survivor := &contextService{started: make(chan struct{})}
+	runtime.ContextService(survivor)
+	runtime.Service(&failingService{})
+
+	err := runtime.Start()
+	if err == nil || !strings.Contains(err.Error(), "failed to start") {
+		t.Errorf("Expected the failure to be returned, but got %v", err)
+	}
+	for _, state := range runtime.serviceStates() {
+		if strings.HasSuffix(state, ": running") {
+			t.Errorf("Expected all services to be stopped once Start returned, but got %v", runtime.serviceStates())
+		}
+	}
+}
+
+func Test_ServiceManager_KeepRunning_LeavesRemainingServicesRunning(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+	runtime.Update(map[string]map[string]string{"runtime": {"failurepolicy": "keeprunning"}})
+
+	survivor := &contextService{started: make(chan struct{})}
+	runtime.ContextService(survivor)
+	runtime.Service(&failingService{})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runtime.Start()
+	}()
+	<-survivor.started
+
+	select {
+	case err := <-done:
+		t.Fatalf("Expected Start to keep running, but it returned %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	runtime.Stop()
+	if err := <-done; err != nil {
+		t.Errorf("Expected failures to be logged only, but got %v", err)
+	}
+}
+
+func Test_ServiceManager_KeepRunning_FailsOnceAllServicesFailed(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+	runtime.Update(map[string]map[string]string{"runtime": {"failurepolicy": "keeprunning"}})
+
+	runtime.Service(&failingService{})
+	runtime.Service(&failingService{})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runtime.Start()
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Expected Start to report the failures once no service is left running")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected Start to return once all services failed")
+	}
+}
+
+func Test_ServiceManager_AddsAndRemovesServicesWhileRunning(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+
+	started := make(chan string, 2)
+	runtime.Service(&orderedService{"db", nil, started, make(chan struct{})})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runtime.Start()
+	}()
+	<-runtime.Ready()
+	<-started
+
+	cache := &orderedService{"cache", []string{"db"}, started, make(chan struct{})}
+	if err := runtime.AddService(cache); err != nil {
+		t.Fatalf("Unexpected error adding a service: %v", err)
+	}
+	select {
+	case name := <-started:
+		if name != "cache" {
+			t.Errorf("Expected the added service to start, but %s did", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the added service to start")
+	}
+	if statuses := runtime.Services(); len(statuses) != 2 || statuses[1].Name != "cache" {
+		t.Errorf("Expected the added service to be reported, but got %v", statuses)
+	}
+
+	if err := runtime.RemoveService("db"); err == nil {
+		t.Errorf("Expected removing a service others depend on to fail")
+	}
+	if err := runtime.RemoveService("cache"); err != nil {
+		t.Errorf("Unexpected error removing a service: %v", err)
+	}
+	select {
+	case <-cache.stop:
+	default:
+		t.Errorf("Expected the removed service to be stopped")
+	}
+	if statuses := runtime.Services(); len(statuses) != 1 || statuses[0].Name != "db" {
+		t.Errorf("Expected the removed service to be gone, but got %v", statuses)
+	}
+
+	if err := runtime.Stop(); err != nil {
+		t.Errorf("Unexpected error stopping services: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error from Start: %v", err)
+	}
+	if err := runtime.AddService(&failingService{}); err == nil {
+		t.Errorf("Expected adding a service after stopping to fail")
+	}
+}
+
+func Test_ServiceManager_AddService_RejectsUnknownDependencies(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+
+	started := make(chan string, 1)
+	runtime.Service(&orderedService{"db", nil, started, make(chan struct{})})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runtime.Start()
+	}()
+	<-runtime.Ready()
+
+	if err := runtime.AddService(&orderedService{"web", []string{"db", "cache"}, started, make(chan struct{})}); err == nil {
+		t.Errorf("Expected a service with unknown dependencies to be rejected")
+	}
+	if err := runtime.RemoveService("db"); err != nil {
+		t.Errorf("Expected the rejected service not to hold on to its dependencies, but got %v", err)
+	}
+
+	runtime.Stop()
+	<-done
+}
+
+type slowStopService struct {
+	orderedService
+	stopping, release chan struct{}
+}
+
+func (service *slowStopService) Stop() error {
+	close(service.stopping)
+	<-service.release
+	return service.orderedService.Stop()
+}
+
+func Test_ServiceManager_Stop_WaitsForServicesBeingRemoved(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+
+	started := make(chan string, 2)
+	db := &orderedService{"db", nil, started, make(chan struct{})}
+	slow := &slowStopService{orderedService{"slow", []string{"db"}, started, make(chan struct{})}, make(chan struct{}), make(chan struct{})}
+	runtime.Service(db)
+	runtime.Service(slow)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runtime.Start()
+	}()
+	<-runtime.Ready()
+
+	removed := make(chan error, 1)
+	go func() {
+		removed <- runtime.RemoveService("slow")
+	}()
+	<-slow.stopping
+	go runtime.Stop()
+
+	select {
+	case <-db.stop:
+		t.Errorf("Expected db to be stopped only after the service depending on it")
+	case err := <-done:
+		t.Errorf("Expected Start to wait for the service being removed, but it returned %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(slow.release)
+	if err := <-removed; err != nil {
+		t.Errorf("Unexpected error removing the service: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Unexpected error from Start: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected Start to return once all services stopped")
+	}
+	select {
+	case <-db.stop:
+	default:
+		t.Errorf("Expected db to be stopped")
+	}
+}
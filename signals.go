@@ -0,0 +1,77 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+var signalNames = map[string]syscall.Signal{
+	"SIGHUP":   syscall.SIGHUP,
+	"SIGQUIT":  syscall.SIGQUIT,
+	"SIGUSR1":  syscall.SIGUSR1,
+	"SIGUSR2":  syscall.SIGUSR2,
+	"SIGTTIN":  syscall.SIGTTIN,
+	"SIGTTOU":  syscall.SIGTTOU,
+	"SIGWINCH": syscall.SIGWINCH,
+}
+
+// configSignal returns the signal named by option in section, or dflt if
+// the option is unset. A value of "none" disables the signal, in which
+// case nil is returned.
+func configSignal(config Config, section, option, dflt string) (os.Signal, error) {
+	name := strings.ToUpper(strings.TrimSpace(config.GetStringDefault(section, option, dflt)))
+	if name == "" || name == "NONE" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	sig, ok := signalNames[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported signal '%s' for [%s] %s", name, section, option)
+	}
+	return sig, nil
+}
+
+// signalOption is a signal configured by an option, for checkSignals.
+type signalOption struct {
+	option string
+	signal os.Signal
+}
+
+// checkSignals returns an error if any of the configured signals is used
+// by more than one option or is one the runtime handles itself, as only
+// one of them would ever take effect.
+func checkSignals(options ...signalOption) error {
+	used := map[os.Signal]string{
+		os.Interrupt:    "stopping",
+		syscall.SIGTERM: "stopping",
+		syscall.SIGHUP:  "reloading",
+	}
+	for _, option := range options {
+		if option.signal == nil {
+			continue
+		}
+		if use, ok := used[option.signal]; ok {
+			return fmt.Errorf("signal %s for %s is already used for %s", signalName(option.signal), option.option, use)
+		}
+		used[option.signal] = option.option
+	}
+	return nil
+}
+
+func signalName(sig os.Signal) string {
+	for name, named := range signalNames {
+		if named == sig {
+			return name
+		}
+	}
+	return sig.String()
+}
@@ -0,0 +1,33 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func Test_CheckSignals_RejectsSignalsUsedTwice(t *testing.T) {
+	err := checkSignals(
+		signalOption{"[log] debugsignal", syscall.SIGUSR2},
+		signalOption{"[runtime] dumpsignal", nil},
+		signalOption{"[runtime] upgradesignal", syscall.SIGUSR2})
+	if err == nil || !strings.Contains(err.Error(), "[log] debugsignal") {
+		t.Errorf("Expected a conflict with the debug signal, but got %v", err)
+	}
+
+	err = checkSignals(signalOption{"[runtime] upgradesignal", syscall.SIGHUP})
+	if err == nil || !strings.Contains(err.Error(), "reloading") {
+		t.Errorf("Expected a conflict with reloading, but got %v", err)
+	}
+
+	err = checkSignals(
+		signalOption{"[log] debugsignal", syscall.SIGUSR1},
+		signalOption{"[runtime] upgradesignal", syscall.SIGUSR2})
+	if err != nil {
+		t.Errorf("Unexpected error for distinct signals: %v", err)
+	}
+}
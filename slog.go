@@ -0,0 +1,139 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+// +build go1.21
+
+package phoenix
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// NewSlogHandler returns a slog.Handler which logs through logger, which
+// should be a Container, Runtime or a logger returned by ComponentLogger.
+//
+// Records are subject to the level of the logger's component and are
+// written to all configured log outputs, with their attributes passed on
+// as structured fields where the output supports them.
+func NewSlogHandler(logger LevelLogger) slog.Handler {
+	handler := &slogHandler{logger: logger}
+	if target, ok := logger.(logTarget); ok {
+		handler.dispatcher, handler.component = target.logTarget()
+	}
+	return handler
+}
+
+// installSlogDefault makes container the destination of the default
+// slog.Logger, which in turn also captures the core logging package. The
+// returned function restores the previous default.
+func installSlogDefault(container *container) (restore func()) {
+	previous := slog.Default()
+	slog.SetDefault(slog.New(NewSlogHandler(container)))
+	return func() {
+		slog.SetDefault(previous)
+	}
+}
+
+type slogHandler struct {
+	logger     LevelLogger
+	dispatcher *logDispatcher
+	component  string
+	fields     []logField
+	prefix     string
+}
+
+func slogLevel(level slog.Level) logLevel {
+	switch {
+	case level >= slog.LevelError:
+		return logLevelError
+	case level >= slog.LevelWarn:
+		return logLevelWarning
+	case level >= slog.LevelInfo:
+		return logLevelInfo
+	}
+	return logLevelDebug
+}
+
+func (handler *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	if handler.dispatcher == nil {
+		return true
+	}
+	return handler.dispatcher.thresholds.enabled(handler.component, slogLevel(level))
+}
+
+func (handler *slogHandler) Handle(_ context.Context, record slog.Record) error {
+	fields := make([]logField, len(handler.fields), len(handler.fields)+record.NumAttrs())
+	copy(fields, handler.fields)
+	record.Attrs(func(attr slog.Attr) bool {
+		fields = appendSlogAttr(fields, handler.prefix, attr)
+		return true
+	})
+
+	level := slogLevel(record.Level)
+	if handler.dispatcher != nil {
+		handler.dispatcher.logFields(handler.component, level, record.Message, fields)
+		return nil
+	}
+
+	// Other loggers don't support fields, so append them to the message.
+	message := record.Message
+	for _, field := range fields {
+		message += fmt.Sprintf(" %s=%q", field.key, field.value)
+	}
+	switch level {
+	case logLevelError:
+		handler.logger.Errorf("%s", message)
+	case logLevelWarning:
+		handler.logger.Warningf("%s", message)
+	case logLevelInfo:
+		handler.logger.Infof("%s", message)
+	default:
+		handler.logger.Debugf("%s", message)
+	}
+	return nil
+}
+
+func (handler *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *handler
+	clone.fields = make([]logField, len(handler.fields), len(handler.fields)+len(attrs))
+	copy(clone.fields, handler.fields)
+	for _, attr := range attrs {
+		clone.fields = appendSlogAttr(clone.fields, handler.prefix, attr)
+	}
+	return &clone
+}
+
+func (handler *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return handler
+	}
+	clone := *handler
+	clone.prefix = handler.prefix + name + "."
+	return &clone
+}
+
+// appendSlogAttr flattens attr into fields, joining the names of groups
+// and their members with dots.
+func appendSlogAttr(fields []logField, prefix string, attr slog.Attr) []logField {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return fields
+	}
+
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, member := range attr.Value.Group() {
+			fields = appendSlogAttr(fields, prefix, member)
+		}
+		return fields
+	}
+
+	return append(fields, logField{strings.TrimSuffix(prefix+attr.Key, "."), attr.Value.String()})
+}
@@ -0,0 +1,13 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !go1.21
+// +build !go1.21
+
+package phoenix
+
+func installSlogDefault(container *container) (restore func()) {
+	// log/slog requires Go 1.21.
+	return func() {}
+}
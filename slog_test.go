@@ -0,0 +1,83 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+// +build go1.21
+
+package phoenix
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func Test_SlogHandler_LogsAttributesAsFields(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phoenix-slog")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	logPath := path.Join(dir, "app.log")
+	options, _ := newLogOptions("test", "", nil)
+	sinks, err := openLogSinks("file:"+logPath+"?format=json", options)
+	if err != nil {
+		t.Fatalf("Unexpected error opening log sinks: %v", err)
+	}
+	thresholds := newLogThresholds(map[string]logLevel{"": logLevelDebug, "db": logLevelInfo})
+	dispatcher := newLogDispatcher("test", sinks, thresholds, nil)
+
+	logger := slog.New(NewSlogHandler(&componentLogger{dispatcher, "db"}))
+	logger.Debug("dropped")
+	logger.WithGroup("query").With("table", "users").Warn("slow", "ms", 250)
+	dispatcher.Close()
+
+	data, _ := ioutil.ReadFile(logPath)
+	var entry struct {
+		Level  string            `json:"level"`
+		Msg    string            `json:"msg"`
+		Fields map[string]string `json:"fields"`
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("Expected a single JSON entry, but got %q: %v", data, err)
+	}
+	if entry.Level != "warning" || entry.Msg != "slow" {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+	for key, value := range map[string]string{"component": "db", "query.table": "users", "query.ms": "250"} {
+		if entry.Fields[key] != value {
+			t.Errorf("Expected field '%s' to be '%s', but got %v", key, value, entry.Fields)
+		}
+	}
+}
+
+func Test_SlogHandler_AppendsAttributesToTextOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phoenix-slog")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	logPath := path.Join(dir, "app.log")
+	options, _ := newLogOptions("test", "", nil)
+	sinks, err := openLogSinks("file:"+logPath, options)
+	if err != nil {
+		t.Fatalf("Unexpected error opening log sinks: %v", err)
+	}
+	dispatcher := newLogDispatcher("test", sinks, newLogThresholds(options.levels), nil)
+
+	logger := slog.New(NewSlogHandler(&componentLogger{dispatcher, "db"}))
+	logger.Warn("slow", "ms", 250)
+	dispatcher.Close()
+
+	data, _ := ioutil.ReadFile(logPath)
+	if line := strings.TrimSpace(string(data)); !strings.HasSuffix(line, ` slow component="db" ms="250"`) {
+		t.Errorf("Expected the attributes to follow the message, but got %q", line)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"time"
+)
+
+// serviceEventBuffer is the number of events buffered for each subscriber.
+const serviceEventBuffer = 64
+
+// ServiceStatus describes the current state of a service.
+type ServiceStatus struct {
+	// Name is the name of the service, see NamedService.
+	Name  string
+	State ServiceState
+	// Started is the time the service last began running, or the zero
+	// time if it never did.
+	Started time.Time
+	// LastError is the last error the service failed with, if any.
+	LastError error
+	// Restarts counts how often the service was restarted.
+	Restarts int
+}
+
+// ServiceEvent describes the transition of a service from one state to
+// another.
+type ServiceEvent struct {
+	Service  string
+	From, To ServiceState
+	Time     time.Time
+	// Err is the error which caused the service to fail, if any.
+	Err error
+}
+
+// Services returns the status of all registered services.
+func (manager *serviceManager) Services() []ServiceStatus {
+	manager.Lock()
+	defer manager.Unlock()
+
+	statuses := make([]ServiceStatus, 0, len(manager.services))
+	for _, service := range manager.services {
+		statuses = append(statuses, ServiceStatus{
+			Name:      service.String(),
+			State:     service.state,
+			Started:   service.started,
+			LastError: service.lastErr,
+			Restarts:  service.restarts,
+		})
+	}
+	return statuses
+}
+
+// Events subscribes to service state transitions. Events are dropped if
+// the subscriber falls behind by more than a few dozen events. Calling the
+// returned function ends the subscription and closes the channel.
+func (manager *serviceManager) Events() (<-chan ServiceEvent, func()) {
+	manager.Lock()
+	defer manager.Unlock()
+
+	events := make(chan ServiceEvent, serviceEventBuffer)
+	if manager.subscribers == nil {
+		manager.subscribers = make(map[chan ServiceEvent]struct{})
+	}
+	manager.subscribers[events] = struct{}{}
+
+	return events, func() {
+		manager.Lock()
+		defer manager.Unlock()
+		if _, ok := manager.subscribers[events]; ok {
+			delete(manager.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// publish sends event to all subscribers. It must be called with the lock
+// held.
+func (manager *serviceManager) publish(event ServiceEvent) {
+	for events := range manager.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Runtime_Services_ReportsStatusAndEvents(t *testing.T) {
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+	runtime.Update(map[string]map[string]string{"runtime": {"restartdelay": "0.01"}})
+
+	events, unsubscribe := runtime.Events()
+	service := &flakyService{failures: 1, stop: make(chan struct{})}
+	runtime.Service(service)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runtime.Start()
+	}()
+	<-runtime.Ready()
+	time.Sleep(50 * time.Millisecond)
+
+	statuses := runtime.Services()
+	if len(statuses) != 1 {
+		t.Fatalf("Expected the status of one service, but got %v", statuses)
+	}
+	status := statuses[0]
+	if status.State != ServiceRunning || status.Restarts != 1 || status.Started.IsZero() {
+		t.Errorf("Expected the service to be running after a restart, but got %+v", status)
+	}
+	if status.LastError == nil || status.LastError.Error() != "flaked" {
+		t.Errorf("Expected the last error to be reported, but got %v", status.LastError)
+	}
+
+	runtime.Stop()
+	<-done
+	unsubscribe()
+
+	var transitions []ServiceState
+	for event := range events {
+		if event.Service != status.Name {
+			t.Errorf("Expected events for %s, but got one for %s", status.Name, event.Service)
+		}
+		if event.To == ServiceFailed && event.Err == nil {
+			t.Errorf("Expected the failure event to carry its error")
+		}
+		transitions = append(transitions, event.To)
+	}
+	expected := []ServiceState{ServiceStarting, ServiceRunning, ServiceFailed, ServiceStarting, ServiceRunning, ServiceStopping, ServiceStopped}
+	if len(transitions) != len(expected) {
+		t.Fatalf("Expected transitions %v, but got %v", expected, transitions)
+	}
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Errorf("Expected transitions %v, but got %v", expected, transitions)
+			break
+		}
+	}
+}
+
+func Test_ServiceState_String_FormatsUnknownStates(t *testing.T) {
+	if name := ServiceState(-1).String(); name != "ServiceState(-1)" {
+		t.Errorf("Expected an unknown state to be formatted as a number, but got %s", name)
+	}
+}
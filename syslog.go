@@ -0,0 +1,303 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/syslog"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	syslogDialTimeout  = 5 * time.Second
+	syslogWriteTimeout = 5 * time.Second
+	syslogMinBackoff   = 500 * time.Millisecond
+	syslogMaxBackoff   = 1 * time.Minute
+
+	// syslogDefaultSDID uses the private enterprise number reserved
+	// for documentation, override it using the syslogsdid option.
+	syslogDefaultSDID = "phoenix@32473"
+)
+
+type syslogFormat int
+
+const (
+	syslogFormatRFC3164 syslogFormat = iota
+	syslogFormatRFC5424
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+var errSyslogUnavailable = errors.New("syslog collector is unavailable, waiting to reconnect")
+
+// syslogOptions holds the [log] settings for syslog outputs.
+type syslogOptions struct {
+	// address is the URL of a remote collector, or empty to use
+	// the local syslog daemon.
+	address  string
+	facility syslog.Priority
+	tag      string
+	format   syslogFormat
+	sdID     string
+	caFile   string
+}
+
+func newSyslogOptions(name string, config *config) (*syslogOptions, error) {
+	options := &syslogOptions{
+		facility: syslog.LOG_DAEMON,
+		tag:      name,
+		sdID:     syslogDefaultSDID,
+	}
+	if config == nil {
+		return options, nil
+	}
+
+	options.address = config.GetStringDefault("log", "syslog", "")
+	options.tag = config.GetStringDefault("log", "syslogtag", name)
+	options.sdID = config.GetStringDefault("log", "syslogsdid", syslogDefaultSDID)
+	options.caFile = config.GetStringDefault("log", "syslogca", "")
+
+	facility := strings.ToLower(config.GetStringDefault("log", "syslogfacility", "daemon"))
+	var ok bool
+	if options.facility, ok = syslogFacilities[facility]; !ok {
+		return nil, fmt.Errorf("unknown syslog facility '%s'", facility)
+	}
+
+	switch format := strings.ToLower(config.GetStringDefault("log", "syslogformat", "rfc3164")); format {
+	case "rfc3164":
+		options.format = syslogFormatRFC3164
+	case "rfc5424":
+		options.format = syslogFormatRFC5424
+	default:
+		return nil, fmt.Errorf("unknown syslog format '%s'", format)
+	}
+
+	return options, nil
+}
+
+func syslogSeverity(level logLevel) syslog.Priority {
+	switch level {
+	case logLevelError:
+		return syslog.LOG_ERR
+	case logLevelWarning:
+		return syslog.LOG_WARNING
+	case logLevelInfo:
+		return syslog.LOG_INFO
+	}
+	return syslog.LOG_DEBUG
+}
+
+// remoteSyslogSink sends records to a syslog collector over UDP, TCP or
+// TLS. Connections are established lazily and re-established with an
+// exponential backoff once they fail.
+//
+// The sink is only ever used from its outputSink's
+// goroutine, so no locking is required.
+type remoteSyslogSink struct {
+	*syslogOptions
+	version   string
+	network   string
+	host      string
+	stream    bool
+	tlsConfig *tls.Config
+	hostname  string
+	pid       int
+	conn      net.Conn
+	backoff   time.Duration
+	retryAt   time.Time
+}
+
+func newRemoteSyslogSink(version string, options *syslogOptions) (*remoteSyslogSink, error) {
+	address, err := url.Parse(options.address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog address '%s': %v", options.address, err)
+	}
+
+	sink := &remoteSyslogSink{
+		syslogOptions: options,
+		version:       version,
+		host:          address.Host,
+		pid:           os.Getpid(),
+	}
+	sink.hostname, _ = os.Hostname()
+
+	switch address.Scheme {
+	case "udp":
+		sink.network = "udp"
+	case "tcp":
+		sink.network, sink.stream = "tcp", true
+	case "tcp+tls":
+		sink.network, sink.stream = "tcp", true
+		if sink.tlsConfig, err = loadSyslogTLSConfig(address.Hostname(), options.caFile); err != nil {
+			return nil, err
+		}
+	case "unix", "unixgram":
+		sink.network, sink.host = "unixgram", address.Path
+	default:
+		return nil, fmt.Errorf("unsupported syslog address '%s'", options.address)
+	}
+
+	return sink, nil
+}
+
+func loadSyslogTLSConfig(serverName, caFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: serverName}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in '%s'", caFile)
+		}
+	}
+	return tlsConfig, nil
+}
+
+func (sink *remoteSyslogSink) connect() error {
+	if sink.conn != nil {
+		return nil
+	}
+	if time.Now().Before(sink.retryAt) {
+		return errSyslogUnavailable
+	}
+
+	dialer := &net.Dialer{Timeout: syslogDialTimeout}
+	var err error
+	if sink.tlsConfig != nil {
+		sink.conn, err = tls.DialWithDialer(dialer, sink.network, sink.host, sink.tlsConfig)
+	} else {
+		sink.conn, err = dialer.Dial(sink.network, sink.host)
+	}
+	if err != nil {
+		sink.conn = nil
+		sink.backoff *= 2
+		if sink.backoff < syslogMinBackoff {
+			sink.backoff = syslogMinBackoff
+		} else if sink.backoff > syslogMaxBackoff {
+			sink.backoff = syslogMaxBackoff
+		}
+		sink.retryAt = time.Now().Add(sink.backoff)
+		return err
+	}
+
+	sink.backoff = 0
+	return nil
+}
+
+func (sink *remoteSyslogSink) writeRecord(record *logRecord, format logFormat) (err error) {
+	// Fields are sent as structured data with RFC 5424.
+	text := record.format(format, true)
+	if sink.format == syslogFormatRFC5424 {
+		text = record.formatMessage(format)
+	}
+	message := sink.formatMessage(record, string(text))
+	if sink.stream {
+		// Use octet counting framing as described in RFC 6587.
+		message = append([]byte(fmt.Sprintf("%d ", len(message))), message...)
+	}
+
+	// Retry once on a fresh connection if an established one went bad.
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = sink.connect(); err != nil {
+			return
+		}
+		sink.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+		if _, err = sink.conn.Write(message); err == nil {
+			return
+		}
+		sink.conn.Close()
+		sink.conn = nil
+	}
+	return
+}
+
+func (sink *remoteSyslogSink) formatMessage(record *logRecord, message string) []byte {
+	priority := sink.facility | syslogSeverity(record.level)
+	buf := &bytes.Buffer{}
+	if sink.format == syslogFormatRFC5424 {
+		fmt.Fprintf(buf, "<%d>1 %s %s %s %d - ", priority,
+			record.time.Format("2006-01-02T15:04:05.000000Z07:00"),
+			syslogHeaderValue(sink.hostname), syslogHeaderValue(sink.tag), sink.pid)
+		sink.writeStructuredData(buf, record)
+		buf.WriteByte(' ')
+	} else {
+		fmt.Fprintf(buf, "<%d>%s %s %s[%d]: ", priority,
+			record.time.Format(time.Stamp), sink.hostname, sink.tag, sink.pid)
+	}
+	buf.WriteString(message)
+	return buf.Bytes()
+}
+
+func (sink *remoteSyslogSink) writeStructuredData(buf *bytes.Buffer, record *logRecord) {
+	fmt.Fprintf(buf, "[%s version=\"%s\"", sink.sdID, syslogParamValue(sink.version))
+	for _, field := range record.fields {
+		fmt.Fprintf(buf, " %s=\"%s\"", syslogParamName(field.key), syslogParamValue(field.value))
+	}
+	buf.WriteByte(']')
+}
+
+func (sink *remoteSyslogSink) Close() error {
+	if sink.conn != nil {
+		return sink.conn.Close()
+	}
+	return nil
+}
+
+// syslogHeaderValue replaces empty header fields with the nil value.
+func syslogHeaderValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.Replace(value, " ", "_", -1)
+}
+
+// syslogParamName restricts name to the characters allowed for an
+// RFC 5424 SD-NAME.
+func syslogParamName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func syslogParamValue(value string) string {
+	return syslogParamEscaper.Replace(value)
+}
@@ -0,0 +1,107 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"bufio"
+	"log/syslog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSyslogSink(t *testing.T, address string, format syslogFormat) *remoteSyslogSink {
+	sink, err := newRemoteSyslogSink("1.2.3", &syslogOptions{
+		address:  address,
+		facility: syslog.LOG_LOCAL3,
+		tag:      "test",
+		format:   format,
+		sdID:     syslogDefaultSDID,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating syslog sink: %v", err)
+	}
+	return sink
+}
+
+func Test_RemoteSyslog_SendsRFC5424MessagesOverUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	sink := newTestSyslogSink(t, "udp://"+conn.LocalAddr().String(), syslogFormatRFC5424)
+	defer sink.Close()
+
+	record := &logRecord{time.Now(), logLevelInfo, "test", "hello", []logField{{"request", `a"b`}}}
+	if err := sink.writeRecord(record, logFormatText); err != nil {
+		t.Fatalf("Unexpected error writing record: %v", err)
+	}
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Failed to receive message: %v", err)
+	}
+
+	message := string(buf[:n])
+	// local3 (19) * 8 + info (6).
+	if !strings.HasPrefix(message, "<158>1 ") {
+		t.Errorf("Expected message to start with the local3.info priority, but was '%s'", message)
+	}
+	if !strings.Contains(message, " test ") {
+		t.Errorf("Expected message '%s' to contain the tag", message)
+	}
+	if expected := `[phoenix@32473 version="1.2.3" request="a\"b"] hello`; !strings.HasSuffix(message, expected) {
+		t.Errorf("Expected message '%s' to end with '%s'", message, expected)
+	}
+}
+
+func Test_RemoteSyslog_ReconnectsOnceTheCollectorIsBack(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	sink := newTestSyslogSink(t, "tcp://"+address, syslogFormatRFC3164)
+	defer sink.Close()
+
+	record := &logRecord{time.Now(), logLevelError, "test", "lost", nil}
+	if err := sink.writeRecord(record, logFormatText); err == nil {
+		t.Fatalf("Expected an error while the collector is down")
+	}
+	if err := sink.writeRecord(record, logFormatText); err != errSyslogUnavailable {
+		t.Errorf("Expected writes to be skipped during the backoff, but got '%v'", err)
+	}
+
+	if listener, err = net.Listen("tcp", address); err != nil {
+		t.Skipf("Could not listen on %s again: %v", address, err)
+	}
+	defer listener.Close()
+
+	sink.retryAt = time.Now()
+	record.message = "found"
+	if err := sink.writeRecord(record, logFormatText); err != nil {
+		t.Fatalf("Unexpected error after the collector came back: %v", err)
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, _ := bufio.NewReader(conn).ReadString(':')
+	// Octet counted frame, followed by local3 (19) * 8 + err (3).
+	if fields := strings.Fields(line); len(fields) < 2 || !strings.HasPrefix(fields[1], "<155>") {
+		t.Errorf("Unexpected frame header '%s'", line)
+	}
+}
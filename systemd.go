@@ -0,0 +1,167 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+// activatedListeners returns the listening sockets passed by systemd as
+// described by sd_listen_fds(3), keyed by "systemd:" and their names from
+// LISTEN_FDNAMES. Sockets without a name are called "unknown", as by
+// systemd. The environment variables are removed so that children don't
+// inherit them.
+func activatedListeners() (map[string][]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if pid := os.Getenv("LISTEN_PID"); pid == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	entries := make([]string, count)
+	for i := range entries {
+		entries[i] = "systemd:unknown"
+		if i < len(names) && names[i] != "" {
+			entries[i] = "systemd:" + names[i]
+		}
+	}
+	return fileListeners(entries)
+}
+
+// fileListeners returns the listening sockets inherited as consecutive
+// file descriptors starting at listenFDsStart, keyed by the given names.
+func fileListeners(names []string) (map[string][]net.Listener, error) {
+	listeners := make(map[string][]net.Listener)
+	for i, name := range names {
+		fd := listenFDsStart + i
+		syscall.CloseOnExec(fd)
+		file := os.NewFile(uintptr(fd), name)
+		listener, err := net.FileListener(file)
+		// FileListener duplicates the descriptor.
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited socket %d (%s) is not a listener: %v", fd, name, err)
+		}
+		listeners[name] = append(listeners[name], listener)
+	}
+	return listeners, nil
+}
+
+// sdNotifier sends state changes to the service manager as described by
+// sd_notify(3). A nil notifier discards all notifications.
+type sdNotifier struct {
+	addr *net.UnixAddr
+	// disabled is set once another process took over as the main
+	// process of the service.
+	disabled int32
+}
+
+// newSDNotifier returns a notifier for the socket given by the environment
+// variable, usually NOTIFY_SOCKET, or nil if it is not set.
+func newSDNotifier(variable string) *sdNotifier {
+	socket := os.Getenv(variable)
+	if socket == "" {
+		return nil
+	}
+	// Names starting with @ refer to the abstract namespace,
+	// which the net package handles for us.
+	return &sdNotifier{addr: &net.UnixAddr{Name: socket, Net: "unixgram"}}
+}
+
+// notify sends the given VARIABLE=value assignments in a single datagram.
+func (notifier *sdNotifier) notify(state ...string) error {
+	if notifier == nil || atomic.LoadInt32(&notifier.disabled) != 0 {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, notifier.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(strings.Join(state, "\n") + "\n"))
+	return err
+}
+
+// disable discards all further notifications.
+func (notifier *sdNotifier) disable() {
+	if notifier != nil {
+		atomic.StoreInt32(&notifier.disabled, 1)
+	}
+}
+
+// watchdogInterval returns the interval at which the service manager
+// expects keep-alive pings, or zero if the watchdog is disabled or meant
+// for another process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// notifyReady tells the service manager once all services are ready and
+// keeps the watchdog fed while they are healthy, until the runtime stops.
+func (runtime *runtime) notifyReady() {
+	select {
+	case <-runtime.Ready():
+	case <-runtime.ctx.Done():
+		return
+	}
+
+	if err := runtime.notifier.notify("READY=1", "STATUS=Running"); err != nil {
+		runtime.Warningf("Failed to notify service manager: %v", err)
+	}
+	if err := runtime.upgradeNotifier.notify("READY=1"); err != nil {
+		runtime.Warningf("Failed to notify upgrading process: %v", err)
+	}
+
+	interval := watchdogInterval()
+	if runtime.notifier == nil || interval <= 0 {
+		return
+	}
+
+	// Ping at half the interval, as recommended by sd_watchdog_enabled(3).
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !runtime.healthy() {
+				runtime.Warningf("Not feeding the watchdog, services have failed")
+				continue
+			}
+			if err := runtime.notifier.notify("WATCHDOG=1"); err != nil {
+				runtime.Warningf("Failed to notify watchdog: %v", err)
+			}
+		case <-runtime.ctx.Done():
+			return
+		}
+	}
+}
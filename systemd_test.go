@@ -0,0 +1,150 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func listenNotifySocket(t *testing.T) (*net.UnixConn, func()) {
+	dir, err := ioutil.TempDir("", "phoenix-notify")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	socket := path.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Failed to listen on %s: %v", socket, err)
+	}
+	os.Setenv("NOTIFY_SOCKET", socket)
+	return conn, func() {
+		os.Unsetenv("NOTIFY_SOCKET")
+		conn.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func expectNotification(t *testing.T, conn *net.UnixConn, state string) {
+	buf := make([]byte, 4096)
+	for {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("Expected notification %s, but got error: %v", state, err)
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if line == state {
+				return
+			}
+		}
+	}
+}
+
+func Test_Runtime_NotifiesServiceManager(t *testing.T) {
+	conn, closeSocket := listenNotifySocket(t)
+	defer closeSocket()
+	os.Setenv("WATCHDOG_USEC", "20000")
+	defer os.Unsetenv("WATCHDOG_USEC")
+
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+
+	service := &contextService{started: make(chan struct{})}
+	runtime.ContextService(service)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runtime.Start()
+	}()
+
+	expectNotification(t, conn, "READY=1")
+	expectNotification(t, conn, "WATCHDOG=1")
+
+	if err := runtime.Reload(); err != nil {
+		t.Errorf("Unexpected error reloading: %v", err)
+	}
+	expectNotification(t, conn, "RELOADING=1")
+
+	runtime.Stop()
+	expectNotification(t, conn, "STOPPING=1")
+	<-done
+}
+
+func Test_WatchdogInterval_IgnoresOtherProcesses(t *testing.T) {
+	os.Setenv("WATCHDOG_USEC", "1000000")
+	defer os.Unsetenv("WATCHDOG_USEC")
+
+	os.Setenv("WATCHDOG_PID", "1")
+	if interval := watchdogInterval(); os.Getpid() != 1 && interval != 0 {
+		t.Errorf("Expected the watchdog to be disabled for another process, but got %s", interval)
+	}
+	os.Unsetenv("WATCHDOG_PID")
+
+	if interval := watchdogInterval(); interval != time.Second {
+		t.Errorf("Expected a watchdog interval of 1s, but got %s", interval)
+	}
+}
+
+// Test_SocketActivationHelper serves as the process receiving sockets in
+// Test_Runtime_UsesActivatedListeners.
+func Test_SocketActivationHelper(t *testing.T) {
+	if os.Getenv("PHOENIX_ACTIVATION_HELPER") == "" {
+		return
+	}
+	// The pid isn't known before starting the process, so
+	// we can't set it the way systemd does.
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+
+	runtime, cleanup := newTestRuntime(t)
+	defer cleanup()
+
+	runtime.Update(map[string]map[string]string{"http": {"listen": "systemd:web"}})
+	runtime.DefaultHTTPHandler(nil)
+	for _, state := range runtime.serviceStates() {
+		fmt.Println(state)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		fmt.Println("environment was not cleared")
+	}
+}
+
+func Test_Runtime_UsesActivatedListeners(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	file, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("Failed to get listener file: %v", err)
+	}
+	defer file.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=Test_SocketActivationHelper")
+	cmd.Env = append(os.Environ(), "PHOENIX_ACTIVATION_HELPER=1", "LISTEN_FDS=1", "LISTEN_FDNAMES=web")
+	cmd.ExtraFiles = []*os.File{file}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Helper process failed: %v\n%s", err, output)
+	}
+
+	expected := fmt.Sprintf("HTTP server on %s: stopped", listener.Addr())
+	if !strings.Contains(string(output), expected) {
+		t.Errorf("Expected the activated listener to be used (%s), but got:\n%s", expected, output)
+	}
+	if strings.Contains(string(output), "environment was not cleared") {
+		t.Errorf("Expected the activation environment to be cleared")
+	}
+}
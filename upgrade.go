@@ -0,0 +1,216 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Environment variables passed to the process being upgraded to. The
+// listeners variable holds the listen entries of the inherited sockets,
+// separated by spaces, in the order of their file descriptors.
+const (
+	upgradeListenersVariable = "PHOENIX_UPGRADE_LISTENERS"
+	upgradePIDVariable       = "PHOENIX_UPGRADE_PID"
+	upgradeNotifyVariable    = "PHOENIX_UPGRADE_NOTIFY_SOCKET"
+)
+
+// inheritableListener is implemented by services whose listening socket
+// may be handed over to an upgraded process.
+type inheritableListener interface {
+	inheritableListener() (entry string, listener net.Listener)
+}
+
+// upgradeListeners returns the sockets passed by the process we were
+// upgraded from, keyed by their listen entries, or nil if there are none.
+func upgradeListeners() (map[string][]net.Listener, error) {
+	defer func() {
+		os.Unsetenv(upgradeListenersVariable)
+		os.Unsetenv(upgradePIDVariable)
+	}()
+
+	if pid := os.Getenv(upgradePIDVariable); pid == "" || pid != strconv.Itoa(os.Getppid()) {
+		return nil, nil
+	}
+	entries := strings.Fields(os.Getenv(upgradeListenersVariable))
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	return fileListeners(entries)
+}
+
+// upgrade replaces the running process by a new instance of its
+// executable, which takes over all listening sockets. Once the new process
+// is ready, all services are stopped. If it fails to become ready within
+// [runtime] upgradetimeout seconds, it is killed and we continue serving.
+func (runtime *runtime) upgrade() {
+	if !atomic.CompareAndSwapInt32(&runtime.upgrading, 0, 1) {
+		runtime.Warningf("Upgrade already in progress")
+		return
+	}
+	defer atomic.StoreInt32(&runtime.upgrading, 0)
+
+	executable, err := os.Executable()
+	if err != nil {
+		runtime.Errorf("Upgrade failed, unable to find executable: %v", err)
+		return
+	}
+
+	timeout := time.Duration(runtime.GetIntDefault("runtime", "upgradetimeout", 30)) * time.Second
+	process, err := runtime.startUpgrade(executable, os.Args[1:], timeout)
+	if err != nil {
+		runtime.Errorf("Upgrade failed, continuing to serve: %v", err)
+		return
+	}
+
+	runtime.Infof("Process %d is ready, stopping all services", process.Pid)
+	if err := runtime.notifier.notify(fmt.Sprintf("MAINPID=%d", process.Pid)); err != nil {
+		runtime.Warningf("Failed to notify service manager: %v", err)
+	}
+	// The new process speaks to the service manager from now on.
+	runtime.notifier.disable()
+	runtime.Stop()
+}
+
+// startUpgrade starts executable with the listening sockets of
+// all services and waits until it reports to be ready.
+func (runtime *runtime) startUpgrade(executable string, args []string, timeout time.Duration) (*os.Process, error) {
+	entries, files, err := runtime.listenerFiles()
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir("", runtime.Name()+"-upgrade")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	socket := path.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	cmd := exec.Command(executable, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(upgradeEnviron(),
+		upgradeListenersVariable+"="+strings.Join(entries, " "),
+		upgradePIDVariable+"="+strconv.Itoa(os.Getpid()),
+		upgradeNotifyVariable+"="+socket)
+	err = cmd.Start()
+	// Passing files to a process puts them in blocking mode, which affects
+	// our listeners as well, as they share the open file descriptions.
+	// Revert that or closing them would block.
+	for _, file := range files {
+		syscall.SetNonblock(int(file.Fd()), true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	runtime.Infof("Started process %d, waiting for it to become ready", cmd.Process.Pid)
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	ready := make(chan struct{})
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			for _, line := range strings.Split(string(buf[:n]), "\n") {
+				if line == "READY=1" {
+					close(ready)
+					return
+				}
+			}
+		}
+	}()
+
+	select {
+	case <-ready:
+		return cmd.Process, nil
+	case err := <-exited:
+		if err == nil {
+			err = errors.New("exit status 0")
+		}
+		return nil, fmt.Errorf("process %d exited before becoming ready: %v", cmd.Process.Pid, err)
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		return nil, fmt.Errorf("process %d did not become ready within %s", cmd.Process.Pid, timeout)
+	}
+}
+
+// listenerFiles returns the listen entries of all services' listening
+// sockets, along with duplicates of the sockets to be inherited.
+func (runtime *runtime) listenerFiles() ([]string, []*os.File, error) {
+	runtime.serviceManager.Lock()
+	defer runtime.serviceManager.Unlock()
+
+	var entries []string
+	var files []*os.File
+	for _, service := range runtime.services {
+		inheritable, ok := service.impl.(inheritableListener)
+		if !ok {
+			continue
+		}
+		entry, listener := inheritable.inheritableListener()
+		if listener == nil {
+			continue
+		}
+		filer, ok := listener.(interface {
+			File() (*os.File, error)
+		})
+		if !ok {
+			return entries, files, fmt.Errorf("listener for %s cannot be inherited", entry)
+		}
+		file, err := filer.File()
+		if err != nil {
+			return entries, files, err
+		}
+		entries = append(entries, entry)
+		files = append(files, file)
+	}
+	return entries, files, nil
+}
+
+// upgradeEnviron returns the environment without variables describing
+// sockets passed to us, which would be wrong for the new process. The
+// watchdog's PID is dropped as well, as it names us rather than the new
+// process, which would then never feed the watchdog.
+func upgradeEnviron() []string {
+	var environ []string
+	for _, variable := range os.Environ() {
+		name := strings.SplitN(variable, "=", 2)[0]
+		if strings.HasPrefix(name, "PHOENIX_UPGRADE_") || strings.HasPrefix(name, "LISTEN_") || name == "WATCHDOG_PID" {
+			continue
+		}
+		environ = append(environ, variable)
+	}
+	return environ
+}
@@ -0,0 +1,128 @@
+// Copyright 2016 struktur AG. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package phoenix
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func textHandler(text string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(text))
+	})
+}
+
+func startUpgradeTestRuntime(t *testing.T, text string) (*runtime, chan error, func()) {
+	runtime, cleanup := newTestRuntime(t)
+	runtime.Update(map[string]map[string]string{"http": {"listen": "127.0.0.1:0"}})
+	runtime.DefaultHTTPHandler(textHandler(text))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runtime.Start()
+	}()
+	select {
+	case <-runtime.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the runtime to become ready")
+	}
+	return runtime, done, cleanup
+}
+
+func getText(t *testing.T, url string) string {
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Failed to request %s: %v", url, err)
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(response.Body)
+	return string(body)
+}
+
+// Test_UpgradeHelper serves as the process upgraded to in the upgrade
+// tests.
+func Test_UpgradeHelper(t *testing.T) {
+	if os.Getenv("PHOENIX_TEST_UPGRADE_HELPER") == "" {
+		return
+	}
+
+	runtime, done, cleanup := startUpgradeTestRuntime(t, "upgraded")
+	defer cleanup()
+
+	// Exit eventually in case the test doesn't kill us.
+	time.Sleep(10 * time.Second)
+	runtime.Stop()
+	<-done
+}
+
+func Test_Runtime_Upgrade_HandsOverListeners(t *testing.T) {
+	runtime, done, cleanup := startUpgradeTestRuntime(t, "original")
+	defer cleanup()
+	url := "http://" + runtime.services[0].impl.(*httpService).listener.Addr().String() + "/"
+
+	os.Setenv("PHOENIX_TEST_UPGRADE_HELPER", "1")
+	process, err := runtime.startUpgrade(os.Args[0], []string{"-test.run=^Test_UpgradeHelper$"}, 5*time.Second)
+	os.Unsetenv("PHOENIX_TEST_UPGRADE_HELPER")
+	if err != nil {
+		t.Fatalf("Unexpected error upgrading: %v", err)
+	}
+	defer process.Kill()
+
+	runtime.Stop()
+	<-done
+
+	if text := getText(t, url); text != "upgraded" {
+		t.Errorf("Expected the upgraded process to serve requests, but got '%s'", text)
+	}
+}
+
+func Test_Runtime_Upgrade_KeepsServingIfTheNewProcessFails(t *testing.T) {
+	runtime, done, cleanup := startUpgradeTestRuntime(t, "original")
+	defer cleanup()
+	url := "http://" + runtime.services[0].impl.(*httpService).listener.Addr().String() + "/"
+
+	// Without the environment variable, the helper exits right away.
+	_, err := runtime.startUpgrade(os.Args[0], []string{"-test.run=^Test_UpgradeHelper$"}, 5*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "exited before becoming ready") {
+		t.Errorf("Expected the upgrade to fail, but got %v", err)
+	}
+
+	if text := getText(t, url); text != "original" {
+		t.Errorf("Expected the original process to keep serving, but got '%s'", text)
+	}
+	runtime.Stop()
+	<-done
+}
+
+func Test_Runtime_Upgrade_LetsTheNewProcessFeedTheWatchdog(t *testing.T) {
+	// The original process is started without a notify socket, so that
+	// all notifications received come from the new one.
+	runtime, done, cleanup := startUpgradeTestRuntime(t, "original")
+	defer cleanup()
+	conn, closeSocket := listenNotifySocket(t)
+	defer closeSocket()
+
+	os.Setenv("WATCHDOG_USEC", "20000")
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("PHOENIX_TEST_UPGRADE_HELPER", "1")
+	process, err := runtime.startUpgrade(os.Args[0], []string{"-test.run=^Test_UpgradeHelper$"}, 5*time.Second)
+	os.Unsetenv("PHOENIX_TEST_UPGRADE_HELPER")
+	os.Unsetenv("WATCHDOG_PID")
+	os.Unsetenv("WATCHDOG_USEC")
+	if err != nil {
+		t.Fatalf("Unexpected error upgrading: %v", err)
+	}
+	defer process.Kill()
+
+	expectNotification(t, conn, "WATCHDOG=1")
+	runtime.Stop()
+	<-done
+}